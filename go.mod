@@ -8,4 +8,5 @@ require (
 	github.com/spf13/cobra v1.8.1
 	github.com/spf13/viper v1.19.0
 	github.com/xanzy/go-gitlab v0.112.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -29,6 +29,8 @@ var dryRun bool
 var gitlabToken string
 var gitlabURL string
 var cfgFile string
+var snapshotDir string
+var auditLog string
 
 // NewRepoDefaultsCmd represents the command
 func NewCmd() *cobra.Command {
@@ -54,9 +56,18 @@ To get started, create a configuration file and pass the --config option.`,
 
 	// Add some flags.
 	cmd.Flags().BoolVarP(&dryRun, "dry-drun", "d", false, "perform a dry run")
-	cmd.Flags().StringVar(&gitlabToken, "gitlab-token", "", "GitLab API token")
-	cmd.Flags().StringVar(&gitlabURL, "gitlab-url", "", "GitLab API URL")
-	cmd.Flags().StringVarP(&cfgFile, "config", "c", "", "path to config file")
+	cmd.PersistentFlags().StringVar(&gitlabToken, "gitlab-token", "", "GitLab API token")
+	cmd.PersistentFlags().StringVar(&gitlabURL, "gitlab-url", "", "GitLab API URL")
+	cmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "path to config file")
+	cmd.PersistentFlags().StringVar(&snapshotDir, "snapshot-dir", gitlab.DefaultSnapshotDir, "directory to write snapshots of changed settings to")
+	cmd.PersistentFlags().StringVar(&auditLog, "audit-log", "", "file to append an audit log of changes to, or \"syslog\"")
+
+	// Add subcommands.
+	cmd.AddCommand(newDaemonCmd())
+	cmd.AddCommand(newExportCmd())
+	cmd.AddCommand(newReportCmd())
+	cmd.AddCommand(newRollbackCmd())
+	cmd.AddCommand(newServeCmd())
 
 	return cmd
 }
@@ -79,6 +90,8 @@ func runCmd() error {
 		if dryRun {
 			cfg.GitLab.DryRun = true
 		}
+		cfg.GitLab.SnapshotDir = snapshotDir
+		cfg.GitLab.AuditLog = auditLog
 
 		// Update projects in configured groups.
 		if cfg.GitLab.Groups != nil {
@@ -0,0 +1,108 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shoekstra/repo-settings/internal/config"
+	"github.com/shoekstra/repo-settings/internal/gitlab"
+	"github.com/spf13/cobra"
+)
+
+var daemonDryRun bool
+var daemonInterval time.Duration
+var daemonJitter time.Duration
+var daemonListen string
+
+// newDaemonCmd returns the daemon command
+func newDaemonCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Keep applying settings on an interval.",
+		Long: `
+Runs in the foreground and applies the settings in the config file to the
+projects in the configured GitLab groups on an interval, only logging the
+settings that changed since the previous run.
+
+The config file is reloaded when it changes, and health and readiness
+endpoints are served on /healthz and /readyz.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runDaemonCmd(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	// Add some flags.
+	cmd.Flags().BoolVarP(&daemonDryRun, "dry-run", "d", false, "perform dry runs")
+	cmd.Flags().DurationVarP(&daemonInterval, "interval", "i", time.Hour, "time between runs")
+	cmd.Flags().DurationVar(&daemonJitter, "jitter", 5*time.Minute, "maximum random time added to the interval")
+	cmd.Flags().StringVarP(&daemonListen, "listen", "l", ":8080", "address to serve the health and readiness endpoints on")
+
+	return cmd
+}
+
+func runDaemonCmd() error {
+	if err := validateCfgFile(); err != nil {
+		return err
+	}
+	if daemonInterval <= 0 || daemonJitter < 0 {
+		return fmt.Errorf("Interval must be positive and jitter can't be negative")
+	}
+
+	d := &gitlab.Daemon{
+		Interval:   daemonInterval,
+		Jitter:     daemonJitter,
+		ConfigFile: cfgFile,
+		Load:       loadDaemonConfig,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- newServer(daemonListen, d.Handler()).ListenAndServe()
+	}()
+	go func() {
+		errs <- d.Run()
+	}()
+
+	return <-errs
+}
+
+// loadDaemonConfig loads the GitLab config used by the daemon from the config
+// file and flags.
+func loadDaemonConfig() (*gitlab.Config, error) {
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.GitLab == nil || cfg.GitLab.Groups == nil {
+		return nil, fmt.Errorf("Config file %s doesn't contain any GitLab groups", cfgFile)
+	}
+
+	if err := cfg.GitLab.LoadCreds(gitlabToken, gitlabURL); err != nil {
+		return nil, err
+	}
+	cfg.GitLab.DryRun = daemonDryRun
+	cfg.GitLab.SnapshotDir = snapshotDir
+	cfg.GitLab.AuditLog = auditLog
+
+	return cfg.GitLab, nil
+}
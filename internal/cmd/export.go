@@ -0,0 +1,83 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/shoekstra/repo-settings/internal/config"
+	"github.com/shoekstra/repo-settings/internal/gitlab"
+	"github.com/spf13/cobra"
+)
+
+var exportGroup string
+var exportOutput string
+
+// newExportCmd returns the export command
+func newExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export a GitLab group's project settings into a config file.",
+		Long: `
+Reads the Merge Request Approval, Protected Branches and Slack settings of
+the projects in a GitLab group and writes them as a config file.
+
+Values used by most projects are set on the group, while projects using a
+different value get a project override.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runExportCmd(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	// Add some flags.
+	cmd.Flags().StringVarP(&exportGroup, "group", "g", "", "GitLab group to export")
+	cmd.Flags().StringVarP(&exportOutput, "output", "o", "", "path to write the config file to, defaults to stdout")
+	cmd.MarkFlagRequired("group")
+
+	return cmd
+}
+
+func runExportCmd() error {
+	cfg := &gitlab.Config{}
+	if err := cfg.LoadCreds(gitlabToken, gitlabURL); err != nil {
+		return err
+	}
+
+	settings, err := gitlab.ExportGroup(cfg, exportGroup)
+	if err != nil {
+		return err
+	}
+
+	data, err := config.Marshal(&config.Config{
+		GitLab: &gitlab.Config{Groups: []*gitlab.Settings{settings}},
+	})
+	if err != nil {
+		return err
+	}
+
+	if exportOutput == "" {
+		fmt.Print(string(data))
+		return nil
+	}
+
+	return ioutil.WriteFile(exportOutput, data, 0644)
+}
@@ -0,0 +1,96 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/shoekstra/repo-settings/internal/config"
+	"github.com/shoekstra/repo-settings/internal/gitlab"
+	"github.com/spf13/cobra"
+)
+
+var reportFormat string
+var reportOutput string
+
+// newReportCmd returns the report command
+func newReportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Report which projects comply with a config file.",
+		Long: `
+Evaluates every project in the configured GitLab groups against the config
+file without changing anything, and writes a matrix of projects by settings
+showing whether each setting passes or fails, along with the offending
+values.
+
+Progress is written to stderr so the report can be written to stdout.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runReportCmd(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	// Add some flags.
+	cmd.Flags().StringVarP(&reportFormat, "format", "f", "markdown", "report format: csv, html or markdown")
+	cmd.Flags().StringVarP(&reportOutput, "output", "o", "", "path to write the report to, defaults to stdout")
+
+	return cmd
+}
+
+func runReportCmd() error {
+	if err := validateCfgFile(); err != nil {
+		return err
+	}
+	if err := gitlab.ValidateReportFormat(reportFormat); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return err
+	}
+	if cfg.GitLab == nil || cfg.GitLab.Groups == nil {
+		return fmt.Errorf("Config file %s doesn't contain any GitLab groups", cfgFile)
+	}
+
+	if err := cfg.GitLab.LoadCreds(gitlabToken, gitlabURL); err != nil {
+		return err
+	}
+
+	// Progress is written to stderr, so the report can be written to stdout.
+	cfg.GitLab.Output = os.Stderr
+	checks, err := gitlab.ReportProjectsInGroups(cfg.GitLab)
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if reportOutput != "" {
+		f, err := os.Create(reportOutput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return gitlab.WriteReport(w, checks, reportFormat)
+}
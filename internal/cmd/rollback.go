@@ -0,0 +1,57 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/shoekstra/repo-settings/internal/gitlab"
+	"github.com/spf13/cobra"
+)
+
+// newRollbackCmd returns the rollback command
+func newRollbackCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollback <run-id>",
+		Short: "Restore the settings changed during a run.",
+		Long: `
+Restores the settings changed during a run to the values they had before the
+run, using the snapshots written while it was running. Settings created
+during the run are removed again.
+
+The run ID is printed at the start of each run.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runRollbackCmd(args[0]); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	return cmd
+}
+
+func runRollbackCmd(runID string) error {
+	cfg := &gitlab.Config{SnapshotDir: snapshotDir, AuditLog: auditLog}
+	if err := cfg.LoadCreds(gitlabToken, gitlabURL); err != nil {
+		return err
+	}
+
+	return gitlab.RollbackRun(cfg, runID)
+}
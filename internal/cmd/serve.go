@@ -0,0 +1,113 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/shoekstra/repo-settings/internal/config"
+	"github.com/shoekstra/repo-settings/internal/gitlab"
+	"github.com/spf13/cobra"
+)
+
+var serveDryRun bool
+var serveListen string
+var serveSecretToken string
+
+// Timeouts of the HTTP servers, so slow or stalled clients can't hold on to
+// connections.
+const (
+	serverReadTimeout  = 10 * time.Second
+	serverWriteTimeout = 30 * time.Second
+	serverIdleTimeout  = 60 * time.Second
+)
+
+// newServeCmd returns the serve command
+func newServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Apply settings to projects as they are created, renamed or transferred.",
+		Long: `
+Runs an HTTP server that receives GitLab system hooks or group webhooks, and
+applies the settings of the matching group in the config file to projects as
+soon as they are created, renamed or transferred.
+
+Webhooks must be sent with the secret token given by --secret-token or the
+GITLAB_WEBHOOK_SECRET env var.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runServeCmd(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	// Add some flags.
+	cmd.Flags().BoolVarP(&serveDryRun, "dry-run", "d", false, "perform a dry run")
+	cmd.Flags().StringVarP(&serveListen, "listen", "l", ":8080", "address to listen on")
+	cmd.Flags().StringVar(&serveSecretToken, "secret-token", "", "secret token webhooks are sent with")
+
+	return cmd
+}
+
+func runServeCmd() error {
+	if err := validateCfgFile(); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return err
+	}
+	if cfg.GitLab == nil || cfg.GitLab.Groups == nil {
+		return fmt.Errorf("Config file %s doesn't contain any GitLab groups", cfgFile)
+	}
+
+	if err := cfg.GitLab.LoadCreds(gitlabToken, gitlabURL); err != nil {
+		return err
+	}
+	cfg.GitLab.DryRun = serveDryRun
+	cfg.GitLab.SnapshotDir = snapshotDir
+	cfg.GitLab.AuditLog = auditLog
+
+	if serveSecretToken == "" {
+		serveSecretToken = os.Getenv("GITLAB_WEBHOOK_SECRET")
+	}
+	handler, err := gitlab.NewWebhookHandler(cfg.GitLab, serveSecretToken)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Listening for webhooks on %s\n", serveListen)
+
+	return newServer(serveListen, handler).ListenAndServe()
+}
+
+// newServer returns an HTTP server for a handler with read and write timeouts.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: serverReadTimeout,
+		ReadTimeout:       serverReadTimeout,
+		WriteTimeout:      serverWriteTimeout,
+		IdleTimeout:       serverIdleTimeout,
+	}
+}
@@ -17,12 +17,15 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"path/filepath"
+	"reflect"
 	"strings"
 
 	"github.com/shoekstra/repo-settings/internal/gitlab"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 // Config represents the app config structure.
@@ -54,6 +57,76 @@ func Load(path string) (*Config, error) {
 	return cfg, err
 }
 
+// Marshal returns a config as YAML that can be read by Load. Keys are written as
+// lowercased field names, the way viper matches them, and empty values are left
+// out.
+func Marshal(cfg *Config) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(marshalValue(reflect.ValueOf(cfg))); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// marshalValue converts a value into maps, slices and plain values that can be
+// marshalled as YAML.
+func marshalValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return marshalValue(v.Elem())
+	case reflect.Struct:
+		m := map[string]interface{}{}
+		marshalFields(v, m)
+		return m
+	case reflect.Slice, reflect.Array:
+		s := []interface{}{}
+		for i := 0; i < v.Len(); i++ {
+			s = append(s, marshalValue(v.Index(i)))
+		}
+		return s
+	case reflect.Map:
+		m := map[string]interface{}{}
+		for _, k := range v.MapKeys() {
+			m[fmt.Sprint(k.Interface())] = marshalValue(v.MapIndex(k))
+		}
+		return m
+	}
+
+	return v.Interface()
+}
+
+// marshalFields adds the non-empty fields of a struct to a map; embedded structs
+// are squashed into it.
+func marshalFields(v reflect.Value, m map[string]interface{}) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" || v.Field(i).IsZero() {
+			continue
+		}
+
+		if f.Anonymous && v.Field(i).Kind() == reflect.Struct {
+			marshalFields(v.Field(i), m)
+			continue
+		}
+
+		value := marshalValue(v.Field(i))
+		if vm, ok := value.(map[string]interface{}); ok && len(vm) == 0 {
+			continue
+		}
+		m[strings.ToLower(f.Name)] = value
+	}
+}
+
 // contains checks a slice for a string and returns true if found.
 func contains(s []string, str string) bool {
 	for _, n := range s {
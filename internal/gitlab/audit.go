@@ -0,0 +1,266 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// auditEntry represents a mutating API call in the audit log. Before holds the
+// setting's state from it's snapshot, After the parameters sent to the API.
+// Rollback is set for calls restoring a setting from it's snapshot.
+type auditEntry struct {
+	Time     time.Time   `json:"time"`
+	RunID    string      `json:"run_id"`
+	Operator string      `json:"operator"`
+	Project  string      `json:"project,omitempty"`
+	Setting  string      `json:"setting,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	Rollback bool        `json:"rollback,omitempty"`
+	Method   string      `json:"method"`
+	Path     string      `json:"path"`
+	Before   interface{} `json:"before,omitempty"`
+	After    interface{} `json:"after,omitempty"`
+	Result   string      `json:"result"`
+}
+
+// auditKeys are suffixes of keys whose values are redacted from the audit log,
+// on top of sensitiveKeys, as they hold CI/CD variable values.
+var auditKeys = []string{"value"}
+
+// auditKey is the context key the attribution of an API call is stored under.
+type auditKey struct{}
+
+// auditInfo attributes an API call to the snapshot of the setting it changes.
+// Secrets are keys whose values are redacted on top of sensitiveKeys and
+// auditKeys.
+type auditInfo struct {
+	snapshot *snapshot
+	rollback bool
+	secrets  []string
+}
+
+// auditOption returns a request option attributing an API call to the snapshot
+// of the setting it changes. As the attribution is stored in the request's
+// context, it only applies to the calls the option is passed to.
+func auditOption(s *snapshot, rollback bool, secrets ...string) gitlab.RequestOptionFunc {
+	info := &auditInfo{snapshot: s, rollback: rollback, secrets: secrets}
+
+	return gitlab.WithContext(context.WithValue(context.Background(), auditKey{}, info))
+}
+
+// auditTransport records every mutating API call made by a client in the audit
+// log, along with the snapshot taken of the setting it changes.
+type auditTransport struct {
+	base http.RoundTripper
+	cfg  *Config
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return t.base.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(data))
+		body = data
+	}
+
+	resp, err := t.base.RoundTrip(req)
+
+	entry := &auditEntry{
+		Time:     time.Now().UTC(),
+		RunID:    t.cfg.RunID,
+		Operator: t.cfg.operator,
+		Method:   req.Method,
+		Path:     req.URL.Path,
+	}
+	var secrets []string
+	if info, ok := req.Context().Value(auditKey{}).(*auditInfo); ok {
+		s := info.snapshot
+		entry.RunID = s.RunID
+		entry.Project = s.Project
+		entry.Setting = s.Setting
+		entry.Name = s.Name
+		entry.Rollback = info.rollback
+		secrets = info.secrets
+
+		// The state a rollback restores is already in it's request.
+		var before interface{}
+		if s.Existed && !info.rollback && json.Unmarshal(s.Before, &before) == nil {
+			entry.Before = redactObject("", before, secrets)
+		}
+	}
+	var after interface{}
+	if len(body) > 0 && json.Unmarshal(body, &after) == nil {
+		entry.After = redactObject("", after, secrets)
+	}
+
+	switch {
+	case err != nil:
+		entry.Result = fmt.Sprintf("failed: %s", err)
+	case resp.StatusCode >= 400:
+		entry.Result = fmt.Sprintf("failed: %s", resp.Status)
+	default:
+		entry.Result = "success"
+	}
+
+	if err := t.cfg.audit(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write audit log: %s\n", err)
+	}
+
+	return resp, err
+}
+
+// newAuditedClient returns a configured GitLab client which, if an audit log is
+// configured, records every change it makes in the audit log. The operator
+// recorded for each change is the user the API token belongs to.
+func newAuditedClient(cfg *Config) (*gitlab.Client, error) {
+	if cfg.AuditLog == "" {
+		return newClient(*cfg.APIToken, *cfg.APIURL)
+	}
+
+	if err := cfg.openAuditLog(); err != nil {
+		return nil, err
+	}
+
+	transport := &auditTransport{base: http.DefaultTransport, cfg: cfg}
+	client, err := gitlab.NewClient(*cfg.APIToken, gitlab.WithBaseURL(*cfg.APIURL), gitlab.WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create client: %v", err)
+	}
+
+	user, _, err := client.Users.CurrentUser()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch current user for the audit log: %s", err)
+	}
+	cfg.operator = user.Username
+
+	return client, nil
+}
+
+// openAuditLog opens the audit log, which is either "syslog" or the path of a
+// file that entries are appended to as JSON lines.
+func (c *Config) openAuditLog() error {
+	if c.auditLog != nil {
+		return nil
+	}
+
+	if strings.EqualFold(c.AuditLog, "syslog") {
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "repo-settings")
+		if err != nil {
+			return fmt.Errorf("Failed to open syslog: %s", err)
+		}
+		c.auditLog = w
+		return nil
+	}
+
+	f, err := os.OpenFile(c.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to open audit log: %s", err)
+	}
+	c.auditLog = f
+
+	return nil
+}
+
+// audit writes an entry to the audit log.
+func (c *Config) audit(entry *auditEntry) error {
+	if c.auditLog == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.auditLog.Write(append(data, '\n'))
+	return err
+}
+
+// redactObject returns a copy of a decoded JSON value with the values of
+// sensitive keys and secrets redacted, and credentials removed from URLs.
+func redactObject(key string, v interface{}, secrets []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for k, mv := range v {
+			m[k] = redactObject(k, mv, secrets)
+		}
+		return m
+	case []interface{}:
+		l := []interface{}{}
+		for _, lv := range v {
+			l = append(l, redactObject(key, lv, secrets))
+		}
+		return l
+	}
+
+	for _, s := range sensitiveKeys {
+		if strings.HasSuffix(strings.ToLower(key), s) {
+			return "[redacted]"
+		}
+	}
+	for _, s := range auditKeys {
+		if strings.HasSuffix(strings.ToLower(key), s) {
+			return "[redacted]"
+		}
+	}
+	for _, s := range secrets {
+		if strings.EqualFold(key, s) {
+			return "[redacted]"
+		}
+	}
+
+	if s, ok := v.(string); ok {
+		return redactURL(s)
+	}
+
+	return v
+}
+
+// redactURL returns a URL without the credentials in it's user info, such as
+// those of a mirror; any other string is returned as is.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.User == nil {
+		return s
+	}
+	u.User = nil
+
+	return u.String()
+}
@@ -0,0 +1,207 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// BadgeSetting represents a project badge. The link and image URLs may contain
+// GitLab placeholders such as %{project_path} and %{default_branch}, which are
+// rendered by GitLab for each project.
+type BadgeSetting struct {
+	Name     string `json:"name"`
+	LinkURL  string `json:"link_url"`
+	ImageURL string `json:"image_url"`
+}
+
+func updateBadgesSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch badges from config file and return if a nil object is returned.
+	cfgSettings := cfg.BadgesSettings(p.PathWithNamespace)
+	if cfgSettings == nil {
+		return nil
+	}
+
+	// Fetch current project badges.
+	projectSettings, err := listProjectBadges(client, p.ID)
+	if err != nil {
+		cfg.fetchFailed(p, "Badges", err)
+		return nil
+	}
+
+	// Loop through badges mentioned in config file
+	for _, cfgSetting := range cfgSettings {
+		if cfgSetting.Name == "" || cfgSetting.LinkURL == "" || cfgSetting.ImageURL == "" {
+			return fmt.Errorf("Badges must have a name, link URL and image URL")
+		}
+
+		// Look for an existing badge; if nothing is found we'll create it.
+		var projectSetting *gitlab.ProjectBadge
+		for _, ps := range projectSettings {
+			if strings.EqualFold(ps.Name, cfgSetting.Name) {
+				projectSetting = ps
+			}
+		}
+
+		if projectSetting == nil {
+			cfg.check(p, "Badges", false, fmt.Sprintf("%s: missing", cfgSetting.Name))
+			cfg.printf("Project %s's %s Badge needs creating ... ", p.PathWithNamespace, cfgSetting.Name)
+
+			if cfg.DryRun {
+				cfg.printf("skipping because this is a dry run\n")
+				continue
+			}
+
+			opts := &gitlab.AddProjectBadgeOptions{
+				Name:     &cfgSetting.Name,
+				LinkURL:  &cfgSetting.LinkURL,
+				ImageURL: &cfgSetting.ImageURL,
+			}
+			audit, err := cfg.snapshot(p, "badge", cfgSetting.Name, false, cfgSetting)
+			if err != nil {
+				return err
+			}
+
+			cfg.printf("Updating project ... ")
+
+			if _, _, err := client.ProjectBadges.AddProjectBadge(p.ID, opts, audit); err != nil {
+				cfg.printf("Failed to create badge: %s\n", err)
+				cfg.updated(p, "Badges", err)
+				continue
+			}
+			cfg.updated(p, "Badges", nil)
+			cfg.printf("Success!\n")
+
+			continue
+		}
+
+		// Compare the templates rather than the rendered URLs, so placeholders are
+		// compared as they are written in the config.
+		if projectSetting.Name == cfgSetting.Name &&
+			projectSetting.LinkURL == cfgSetting.LinkURL &&
+			projectSetting.ImageURL == cfgSetting.ImageURL {
+			cfg.check(p, "Badges", true, "")
+			cfg.printf("Project %s's %s Badge doesn't need updating\n", p.PathWithNamespace, cfgSetting.Name)
+			continue
+		}
+
+		current := &BadgeSetting{Name: projectSetting.Name, LinkURL: projectSetting.LinkURL, ImageURL: projectSetting.ImageURL}
+		cfg.check(p, "Badges", false, fmt.Sprintf("%s: %s", cfgSetting.Name, diffObjects(current, cfgSetting)))
+		cfg.printf("Project %s's %s Badge needs updating ... ", p.PathWithNamespace, cfgSetting.Name)
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			continue
+		}
+
+		opts := &gitlab.EditProjectBadgeOptions{
+			Name:     &cfgSetting.Name,
+			LinkURL:  &cfgSetting.LinkURL,
+			ImageURL: &cfgSetting.ImageURL,
+		}
+		audit, err := cfg.snapshot(p, "badge", cfgSetting.Name, true, projectSetting)
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		if _, _, err := client.ProjectBadges.EditProjectBadge(p.ID, projectSetting.ID, opts, audit); err != nil {
+			cfg.printf("Failed to update badge: %s\n", err)
+			cfg.updated(p, "Badges", err)
+			continue
+		}
+		cfg.updated(p, "Badges", nil)
+		cfg.printf("Success!\n")
+	}
+
+	return nil
+}
+
+// restoreBadge restores a badge's name and URLs, recreating it if it was
+// removed, or removes it if it didn't exist.
+func restoreBadge(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &BadgeSetting{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	projectSettings, err := listProjectBadges(client, s.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	var projectSetting *gitlab.ProjectBadge
+	for _, ps := range projectSettings {
+		if strings.EqualFold(ps.Name, before.Name) {
+			projectSetting = ps
+		}
+	}
+
+	switch {
+	case !s.Existed && projectSetting == nil:
+		return nil
+	case !s.Existed:
+		_, err = client.ProjectBadges.DeleteProjectBadge(s.ProjectID, projectSetting.ID, audit)
+	case projectSetting == nil:
+		opts := &gitlab.AddProjectBadgeOptions{Name: &before.Name, LinkURL: &before.LinkURL, ImageURL: &before.ImageURL}
+		_, _, err = client.ProjectBadges.AddProjectBadge(s.ProjectID, opts, audit)
+	default:
+		opts := &gitlab.EditProjectBadgeOptions{Name: &before.Name, LinkURL: &before.LinkURL, ImageURL: &before.ImageURL}
+		_, _, err = client.ProjectBadges.EditProjectBadge(s.ProjectID, projectSetting.ID, opts, audit)
+	}
+
+	return err
+}
+
+// listProjectBadges returns a slice containing all badges created in a project;
+// badges inherited from groups are left out.
+func listProjectBadges(client *gitlab.Client, pid int) ([]*gitlab.ProjectBadge, error) {
+	badges := []*gitlab.ProjectBadge{}
+
+	opt := &gitlab.ListProjectBadgesOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+			Page:    1,
+		},
+	}
+
+	for {
+		bs, resp, err := client.ProjectBadges.ListProjectBadges(pid, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range bs {
+			if b.Kind == "project" {
+				badges = append(badges, b)
+			}
+		}
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		opt.Page = resp.NextPage
+	}
+
+	return badges, nil
+}
@@ -0,0 +1,96 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+// ChatSettings represents a project's settings for a chat notification
+// integration, such as Microsoft Teams or Mattermost.
+type ChatSettings struct {
+	Mode       string         `json:"mode,omitempty"`
+	Active     bool           `json:"active"`
+	Events     []string       `json:"events"`
+	Properties ChatProperties `json:"properties,omitempty"`
+}
+
+// ChatProperties represents the properties shared by chat notification
+// integrations.
+type ChatProperties struct {
+	WebHook                   string `json:"webhook,omitempty"`
+	Username                  string `json:"username,omitempty"`
+	Channel                   string `json:"channel,omitempty"`
+	BranchesToBeNotified      string `json:"branches_to_be_notified,omitempty"`
+	NotifyOnlyBrokenPipelines *bool  `json:"notify_only_broken_pipelines,omitempty"`
+}
+
+// chatEvents are the events supported by every chat notification integration.
+var chatEvents = []string{
+	"confidential_issues",
+	"confidential_note",
+	"issues",
+	"merge_request",
+	"note",
+	"pipeline",
+	"push",
+	"tags",
+	"wiki_page",
+}
+
+// chatProperties are the properties supported by every chat notification
+// integration.
+var chatProperties = []string{
+	"branches_to_be_notified",
+	"notify_only_broken_pipelines",
+	"webhook",
+}
+
+// chatBooleans are the boolean properties supported by every chat notification
+// integration.
+var chatBooleans = []string{
+	"notify_only_broken_pipelines",
+}
+
+// integrationSettings converts chat settings into the generic integration
+// settings used by the integrations table.
+func (c *ChatSettings) integrationSettings() *IntegrationSettings {
+	if compareObjects(c, &ChatSettings{}) {
+		return &IntegrationSettings{}
+	}
+
+	s := &IntegrationSettings{
+		Mode:       c.Mode,
+		Active:     c.Active,
+		Events:     c.Events,
+		Properties: map[string]interface{}{},
+	}
+
+	if c.Properties.WebHook != "" {
+		s.Properties["webhook"] = c.Properties.WebHook
+	}
+	if c.Properties.Username != "" {
+		s.Properties["username"] = c.Properties.Username
+	}
+	if c.Properties.Channel != "" {
+		s.Properties["channel"] = c.Properties.Channel
+	}
+	if c.Properties.BranchesToBeNotified != "" {
+		s.Properties["branches_to_be_notified"] = c.Properties.BranchesToBeNotified
+	}
+	if c.Properties.NotifyOnlyBrokenPipelines != nil {
+		s.Properties["notify_only_broken_pipelines"] = *c.Properties.NotifyOnlyBrokenPipelines
+	}
+
+	return s
+}
@@ -0,0 +1,393 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/imdario/mergo"
+	"github.com/jinzhu/copier"
+	"github.com/xanzy/go-gitlab"
+)
+
+// CIGeneralSettings represents a project's general CI/CD pipeline settings.
+type CIGeneralSettings struct {
+	CIConfigPath               string                `json:"ci_config_path,omitempty"`
+	CIDefaultGitDepth          int                   `json:"ci_default_git_depth,omitempty"`
+	BuildTimeout               int                   `json:"build_timeout,omitempty"`
+	AutoCancelPendingPipelines string                `json:"auto_cancel_pending_pipelines,omitempty"`
+	PublicJobs                 *bool                 `json:"public_jobs,omitempty"`
+	BuildCoverageRegex         string                `json:"build_coverage_regex,omitempty"`
+	SharedRunnersEnabled       *bool                 `json:"shared_runners_enabled,omitempty"`
+	JobTokenScope              JobTokenScopeSettings `json:"job_token_scope,omitempty"`
+}
+
+// JobTokenScopeSettings represents a project's CI/CD job token scope; projects and
+// groups are listed by their full path and are added to the allowlist if missing.
+type JobTokenScopeSettings struct {
+	Enabled  *bool    `json:"enabled,omitempty"`
+	Projects []string `json:"projects,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+func updateCIGeneralSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch general CI/CD settings from config file and return if a nil object is returned.
+	cfgSettings := cfg.CIGeneralSettings(p.PathWithNamespace)
+	if cfgSettings == nil || compareObjects(cfgSettings, &CIGeneralSettings{}) {
+		return nil
+	}
+
+	if err := updatePipelineSettings(client, p, cfg, cfgSettings); err != nil {
+		return err
+	}
+
+	return updateJobTokenScopeSettings(client, p, cfg, &cfgSettings.JobTokenScope)
+}
+
+func updatePipelineSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config, cfgSettings *CIGeneralSettings) error {
+	switch cfgSettings.AutoCancelPendingPipelines {
+	case "", "enabled", "disabled":
+	default:
+		return fmt.Errorf("Invalid auto_cancel_pending_pipelines setting: %s", cfgSettings.AutoCancelPendingPipelines)
+	}
+
+	// Fetch current project settings.
+	projectSettings, _, err := client.Projects.GetProject(p.ID, nil)
+	if err != nil {
+		cfg.fetchFailed(p, "CI/CD General pipelines", err)
+		return nil
+	}
+
+	// Take what is current and apply our changes on top, thus we do not override any
+	// settings that we don't define in our config.
+	newSettings := &gitlab.Project{}
+	if err := copier.Copy(newSettings, projectSettings); err != nil {
+		return err
+	}
+
+	cfgProject := &gitlab.Project{
+		CIConfigPath:               cfgSettings.CIConfigPath,
+		CIDefaultGitDepth:          cfgSettings.CIDefaultGitDepth,
+		BuildTimeout:               cfgSettings.BuildTimeout,
+		AutoCancelPendingPipelines: cfgSettings.AutoCancelPendingPipelines,
+		BuildCoverageRegex:         cfgSettings.BuildCoverageRegex,
+	}
+	if err := mergo.Merge(newSettings, cfgProject, mergo.WithOverride); err != nil {
+		return err
+	}
+	// Booleans are set separately as mergo won't override a value with false.
+	if cfgSettings.PublicJobs != nil {
+		newSettings.PublicJobs = *cfgSettings.PublicJobs
+	}
+	if cfgSettings.SharedRunnersEnabled != nil {
+		newSettings.SharedRunnersEnabled = *cfgSettings.SharedRunnersEnabled
+	}
+
+	// Return if our proposed config matches the actual config
+	if compareObjects(projectSettings, newSettings) {
+		cfg.check(p, "CI/CD General pipelines", true, "")
+		cfg.printf("Project %s's CI/CD General pipelines settings don't need updating\n", p.PathWithNamespace)
+		return nil
+	}
+
+	cfg.check(p, "CI/CD General pipelines", false, diffObjects(projectSettings, newSettings))
+
+	cfg.printf("Project %s's CI/CD General pipelines settings need updating ... ", p.PathWithNamespace)
+
+	if cfg.DryRun {
+		cfg.printf("skipping because this is a dry run\n")
+		return nil
+	}
+
+	opts := &gitlab.EditProjectOptions{
+		CIConfigPath:               &newSettings.CIConfigPath,
+		CIDefaultGitDepth:          &newSettings.CIDefaultGitDepth,
+		BuildTimeout:               &newSettings.BuildTimeout,
+		AutoCancelPendingPipelines: &newSettings.AutoCancelPendingPipelines,
+		PublicBuilds:               &newSettings.PublicJobs,
+		BuildCoverageRegex:         &newSettings.BuildCoverageRegex,
+		SharedRunnersEnabled:       &newSettings.SharedRunnersEnabled,
+	}
+	audit, err := cfg.snapshot(p, "project", "CI/CD General pipelines", true, projectAttributes(projectSettings, opts))
+	if err != nil {
+		return err
+	}
+
+	cfg.printf("Updating project ... ")
+
+	_, _, err = client.Projects.EditProject(p.ID, opts, audit)
+	if err != nil {
+		cfg.updated(p, "CI/CD General pipelines", err)
+		return err
+	}
+	cfg.updated(p, "CI/CD General pipelines", nil)
+	cfg.printf("Success!\n")
+
+	return nil
+}
+
+func updateJobTokenScopeSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config, cfgSettings *JobTokenScopeSettings) error {
+	if compareObjects(cfgSettings, &JobTokenScopeSettings{}) {
+		return nil
+	}
+
+	if cfgSettings.Enabled != nil {
+		projectSettings, _, err := client.JobTokenScope.GetProjectJobTokenAccessSettings(p.ID)
+		if err != nil {
+			cfg.fetchFailed(p, "CI/CD job token scope", err)
+			return nil
+		}
+
+		cfg.check(p, "CI/CD job token scope", projectSettings.InboundEnabled == *cfgSettings.Enabled,
+			fmt.Sprintf("enabled: %t -> %t", projectSettings.InboundEnabled, *cfgSettings.Enabled))
+
+		if projectSettings.InboundEnabled == *cfgSettings.Enabled {
+			cfg.printf("Project %s's CI/CD job token scope settings don't need updating\n", p.PathWithNamespace)
+		} else {
+			cfg.printf("Project %s's CI/CD job token scope settings need updating ... ", p.PathWithNamespace)
+
+			if cfg.DryRun {
+				cfg.printf("skipping because this is a dry run\n")
+			} else {
+				audit, err := cfg.snapshot(p, "job_token_scope", "", true, projectSettings)
+				if err != nil {
+					return err
+				}
+
+				cfg.printf("Updating project ... ")
+
+				opts := &gitlab.PatchProjectJobTokenAccessSettingsOptions{Enabled: *cfgSettings.Enabled}
+				if _, err := client.JobTokenScope.PatchProjectJobTokenAccessSettings(p.ID, opts, audit); err != nil {
+					cfg.updated(p, "CI/CD job token scope", err)
+					return err
+				}
+				cfg.updated(p, "CI/CD job token scope", nil)
+				cfg.printf("Success!\n")
+			}
+		}
+	}
+
+	if len(cfgSettings.Projects) > 0 {
+		allowed, err := listJobTokenAllowlistProjects(client, p.ID)
+		if err != nil {
+			cfg.fetchFailed(p, "CI/CD job token scope", err)
+			return nil
+		}
+
+		for _, path := range cfgSettings.Projects {
+			if contains(allowed, strings.ToLower(path)) {
+				cfg.check(p, "CI/CD job token scope", true, "")
+				cfg.printf("Project %s's CI/CD job token allowlist already contains project %s\n", p.PathWithNamespace, path)
+				continue
+			}
+
+			// A project that can't be found can't be added, so this is reported as
+			// failing rather than as a project that needs adding.
+			target, _, err := client.Projects.GetProject(path, nil)
+			if err != nil {
+				cfg.check(p, "CI/CD job token scope", false, fmt.Sprintf("project %s can't be found: %s", path, err))
+				cfg.printf("Project %s's CI/CD job token allowlist can't add project %s: %s\n", p.PathWithNamespace, path, err)
+				continue
+			}
+
+			cfg.check(p, "CI/CD job token scope", false, fmt.Sprintf("project %s isn't allowed", path))
+
+			cfg.printf("Project %s's CI/CD job token allowlist needs project %s adding ... ", p.PathWithNamespace, path)
+
+			if cfg.DryRun {
+				cfg.printf("skipping because this is a dry run\n")
+				continue
+			}
+
+			before := &gitlab.Project{ID: target.ID, PathWithNamespace: target.PathWithNamespace}
+			audit, err := cfg.snapshot(p, "job_token_allowlist_project", path, false, before)
+			if err != nil {
+				return err
+			}
+
+			cfg.printf("Updating project ... ")
+
+			opts := &gitlab.JobTokenInboundAllowOptions{TargetProjectID: &target.ID}
+			if _, _, err := client.JobTokenScope.AddProjectToJobScopeAllowList(p.ID, opts, audit); err != nil {
+				cfg.printf("Failed to add project: %s\n", err)
+				cfg.updated(p, "CI/CD job token scope", err)
+				continue
+			}
+			cfg.updated(p, "CI/CD job token scope", nil)
+			cfg.printf("Success!\n")
+		}
+	}
+
+	if len(cfgSettings.Groups) > 0 {
+		allowed, err := listJobTokenAllowlistGroups(client, p.ID)
+		if err != nil {
+			cfg.fetchFailed(p, "CI/CD job token scope", err)
+			return nil
+		}
+
+		for _, path := range cfgSettings.Groups {
+			if contains(allowed, strings.ToLower(path)) {
+				cfg.check(p, "CI/CD job token scope", true, "")
+				cfg.printf("Project %s's CI/CD job token allowlist already contains group %s\n", p.PathWithNamespace, path)
+				continue
+			}
+
+			// A group that can't be found can't be added, so this is reported as
+			// failing rather than as a group that needs adding.
+			target, _, err := client.Groups.GetGroup(path, nil)
+			if err != nil {
+				cfg.check(p, "CI/CD job token scope", false, fmt.Sprintf("group %s can't be found: %s", path, err))
+				cfg.printf("Project %s's CI/CD job token allowlist can't add group %s: %s\n", p.PathWithNamespace, path, err)
+				continue
+			}
+
+			cfg.check(p, "CI/CD job token scope", false, fmt.Sprintf("group %s isn't allowed", path))
+
+			cfg.printf("Project %s's CI/CD job token allowlist needs group %s adding ... ", p.PathWithNamespace, path)
+
+			if cfg.DryRun {
+				cfg.printf("skipping because this is a dry run\n")
+				continue
+			}
+
+			before := &gitlab.Group{ID: target.ID, FullPath: target.FullPath}
+			audit, err := cfg.snapshot(p, "job_token_allowlist_group", path, false, before)
+			if err != nil {
+				return err
+			}
+
+			cfg.printf("Updating project ... ")
+
+			opts := &gitlab.AddGroupToJobTokenAllowlistOptions{TargetGroupID: &target.ID}
+			if _, _, err := client.JobTokenScope.AddGroupToJobTokenAllowlist(p.ID, opts, audit); err != nil {
+				cfg.printf("Failed to add group: %s\n", err)
+				cfg.updated(p, "CI/CD job token scope", err)
+				continue
+			}
+			cfg.updated(p, "CI/CD job token scope", nil)
+			cfg.printf("Success!\n")
+		}
+	}
+
+	return nil
+}
+
+// restoreJobTokenScope restores whether access to a project using CI/CD job
+// tokens is limited.
+func restoreJobTokenScope(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.JobTokenAccessSettings{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	opts := &gitlab.PatchProjectJobTokenAccessSettingsOptions{Enabled: before.InboundEnabled}
+	_, err := client.JobTokenScope.PatchProjectJobTokenAccessSettings(s.ProjectID, opts, audit)
+
+	return err
+}
+
+// restoreJobTokenAllowlistProject removes a project added to the CI/CD job token
+// allowlist.
+func restoreJobTokenAllowlistProject(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.Project{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	_, err := client.JobTokenScope.RemoveProjectFromJobScopeAllowList(s.ProjectID, before.ID, audit)
+
+	return err
+}
+
+// restoreJobTokenAllowlistGroup removes a group added to the CI/CD job token
+// allowlist.
+func restoreJobTokenAllowlistGroup(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.Group{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	_, err := client.JobTokenScope.RemoveGroupFromJobTokenAllowlist(s.ProjectID, before.ID, audit)
+
+	return err
+}
+
+// listJobTokenAllowlistProjects returns the lowercased paths of all projects in a
+// project's CI/CD job token allowlist.
+func listJobTokenAllowlistProjects(client *gitlab.Client, pid int) ([]string, error) {
+	paths := []string{}
+
+	opt := &gitlab.GetJobTokenInboundAllowListOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+			Page:    1,
+		},
+	}
+
+	for {
+		ps, resp, err := client.JobTokenScope.GetProjectJobTokenInboundAllowList(pid, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range ps {
+			paths = append(paths, strings.ToLower(p.PathWithNamespace))
+		}
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		opt.Page = resp.NextPage
+	}
+
+	return paths, nil
+}
+
+// listJobTokenAllowlistGroups returns the lowercased paths of all groups in a
+// project's CI/CD job token allowlist.
+func listJobTokenAllowlistGroups(client *gitlab.Client, pid int) ([]string, error) {
+	paths := []string{}
+
+	opt := &gitlab.GetJobTokenAllowlistGroupsOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+			Page:    1,
+		},
+	}
+
+	for {
+		gs, resp, err := client.JobTokenScope.GetJobTokenAllowlistGroups(pid, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, g := range gs {
+			paths = append(paths, strings.ToLower(g.FullPath))
+		}
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		opt.Page = resp.NextPage
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,135 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdatePipelineSettings(t *testing.T) {
+	current := `{"id": 1, "path_with_namespace": "acme/api", "ci_config_path": ".gitlab-ci.yml", "build_timeout": 3600, "public_jobs": true}`
+
+	tests := []struct {
+		name       string
+		timeout    int
+		publicJobs bool
+		wantWrites []string
+		wantBody   []string
+	}{
+		{
+			name:       "matches",
+			timeout:    3600,
+			publicJobs: true,
+			wantWrites: []string{},
+		},
+		{
+			name:       "differs",
+			timeout:    7200,
+			wantWrites: []string{"PUT /projects/1"},
+			wantBody:   []string{`"build_timeout":7200`, `"public_builds":false`, `"ci_config_path":".gitlab-ci.yml"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client, cfg := newFakeGitLab(t, map[string]string{
+				"GET /projects/1": current,
+				"PUT /projects/1": current,
+			})
+			cfg.Groups[0].CI.General.BuildTimeout = tt.timeout
+			cfg.Groups[0].CI.General.PublicJobs = &tt.publicJobs
+
+			if err := updateCIGeneralSettings(client, testProject, cfg); err != nil {
+				t.Fatalf("updateCIGeneralSettings() error = %v", err)
+			}
+
+			if got := f.writes(); !reflect.DeepEqual(got, tt.wantWrites) {
+				t.Fatalf("writes = %v, want %v", got, tt.wantWrites)
+			}
+			check := onlyCheck(t, cfg, "CI/CD General pipelines")
+			if check.Compliant != (len(tt.wantWrites) == 0) {
+				t.Errorf("check = %+v", check)
+			}
+
+			if len(tt.wantWrites) == 0 {
+				return
+			}
+			body := f.request(t, "PUT /projects/1").Body
+			for _, want := range tt.wantBody {
+				if !strings.Contains(body, want) {
+					t.Errorf("request body %s doesn't contain %s", body, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateJobTokenScopeSettings(t *testing.T) {
+	f, client, cfg := newFakeGitLab(t, map[string]string{
+		"GET /projects/1/job_token_scope":            `{"inbound_enabled": false}`,
+		"PATCH /projects/1/job_token_scope":          ``,
+		"GET /projects/1/job_token_scope/allowlist":  `[{"id": 2, "path_with_namespace": "acme/web"}]`,
+		"POST /projects/1/job_token_scope/allowlist": `{"source_project_id": 1, "target_project_id": 3}`,
+		"GET /projects/acme/lib":                     `{"id": 3, "path_with_namespace": "acme/lib"}`,
+	})
+	enabled := true
+	cfg.Groups[0].CI.General.JobTokenScope = JobTokenScopeSettings{
+		Enabled:  &enabled,
+		Projects: []string{"acme/web", "acme/lib", "acme/gone"},
+		// The groups allowlist can't be fetched, as the stub doesn't know it.
+		Groups: []string{"acme/deploy"},
+	}
+
+	if err := updateCIGeneralSettings(client, testProject, cfg); err != nil {
+		t.Fatalf("updateCIGeneralSettings() error = %v", err)
+	}
+
+	wantWrites := []string{
+		"PATCH /projects/1/job_token_scope",
+		"POST /projects/1/job_token_scope/allowlist",
+	}
+	if got := f.writes(); !reflect.DeepEqual(got, wantWrites) {
+		t.Errorf("writes = %v, want %v", got, wantWrites)
+	}
+	if body := f.request(t, "POST /projects/1/job_token_scope/allowlist").Body; !strings.Contains(body, `"target_project_id":3`) {
+		t.Errorf("request body %s doesn't add project 3", body)
+	}
+
+	type result struct {
+		compliant bool
+		updated   bool
+		details   string
+	}
+	got := []result{}
+	for _, c := range cfg.checks {
+		if c.Setting == "CI/CD job token scope" {
+			got = append(got, result{c.Compliant, c.Updated, c.Details})
+		}
+	}
+	want := []result{
+		{false, true, "enabled: false -> true"},
+		{true, false, ""},
+		{false, true, "project acme/lib isn't allowed"},
+		{false, false, "project acme/gone can't be found: 404 Not Found"},
+		{false, false, "failed to fetch current settings: 404 Not Found"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checks = %+v, want %+v", got, want)
+	}
+}
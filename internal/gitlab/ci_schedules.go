@@ -0,0 +1,387 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// ScheduleSetting represents a pipeline schedule. Schedules are identified by
+// their description; the ref defaults to the project's default branch.
+type ScheduleSetting struct {
+	Description   string                     `json:"description"`
+	Cron          string                     `json:"cron"`
+	CronTimezone  string                     `json:"cron_timezone,omitempty"`
+	Ref           string                     `json:"ref,omitempty"`
+	Active        *bool                      `json:"active,omitempty"`
+	Variables     []*ScheduleVariableSetting `json:"variables,omitempty"`
+	TakeOwnership bool                       `json:"take_ownership,omitempty"`
+}
+
+// ScheduleVariableSetting represents a variable passed to pipelines started by a
+// schedule.
+type ScheduleVariableSetting struct {
+	Key          string `json:"key"`
+	Value        string `json:"value"`
+	VariableType string `json:"variable_type,omitempty"`
+}
+
+func updateCISchedulesSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch pipeline schedules from config file and return if a nil object is returned.
+	cfgSettings := cfg.CISchedulesSettings(p.PathWithNamespace)
+	if cfgSettings == nil {
+		return nil
+	}
+
+	// Fetch current pipeline schedules.
+	projectSettings, err := listPipelineSchedules(client, p.ID)
+	if err != nil {
+		cfg.fetchFailed(p, "Pipeline schedules", err)
+		return nil
+	}
+
+	// Loop through schedules mentioned in config file
+	for _, cfgSetting := range cfgSettings {
+		if cfgSetting.Description == "" || cfgSetting.Cron == "" {
+			return fmt.Errorf("Pipeline schedules must have a description and cron")
+		}
+		for _, v := range cfgSetting.Variables {
+			if v.Key == "" {
+				return fmt.Errorf("Pipeline schedule variables must have a key")
+			}
+			if v.VariableType != "" && !contains([]string{"env_var", "file"}, v.VariableType) {
+				return fmt.Errorf("Invalid pipeline schedule variable_type: %s", v.VariableType)
+			}
+		}
+
+		ref := cfgSetting.Ref
+		if ref == "" {
+			ref = p.DefaultBranch
+		}
+
+		// Look for an existing schedule; if nothing is found we'll create it.
+		var projectSetting *gitlab.PipelineSchedule
+		for _, ps := range projectSettings {
+			if ps.Description == cfgSetting.Description {
+				projectSetting = ps
+			}
+		}
+
+		if projectSetting == nil {
+			cfg.check(p, "Pipeline schedules", false, fmt.Sprintf("%s is missing", cfgSetting.Description))
+			cfg.printf("Project %s's %s Pipeline Schedule needs creating ... ", p.PathWithNamespace, cfgSetting.Description)
+
+			if cfg.DryRun {
+				cfg.printf("skipping because this is a dry run\n")
+				continue
+			}
+
+			opts := &gitlab.CreatePipelineScheduleOptions{
+				Description: &cfgSetting.Description,
+				Ref:         &ref,
+				Cron:        &cfgSetting.Cron,
+				Active:      cfgSetting.Active,
+			}
+			if cfgSetting.CronTimezone != "" {
+				opts.CronTimezone = &cfgSetting.CronTimezone
+			}
+			audit, err := cfg.snapshot(p, "pipeline_schedule", cfgSetting.Description, false, &gitlab.PipelineSchedule{Description: cfgSetting.Description})
+			if err != nil {
+				return err
+			}
+
+			cfg.printf("Updating project ... ")
+
+			schedule, _, err := client.PipelineSchedules.CreatePipelineSchedule(p.ID, opts, audit)
+			if err != nil {
+				cfg.printf("Failed to create pipeline schedule: %s\n", err)
+				cfg.updated(p, "Pipeline schedules", err)
+				continue
+			}
+			if err := updateScheduleVariables(client, p.ID, schedule, cfgSetting.Variables, audit); err != nil {
+				cfg.printf("Failed to set pipeline schedule variables: %s\n", err)
+				cfg.updated(p, "Pipeline schedules", err)
+				continue
+			}
+			cfg.updated(p, "Pipeline schedules", nil)
+			cfg.printf("Success!\n")
+
+			continue
+		}
+
+		// Variables are only returned when fetching a single schedule.
+		schedule, _, err := client.PipelineSchedules.GetPipelineSchedule(p.ID, projectSetting.ID)
+		if err != nil {
+			cfg.fetchFailed(p, "Pipeline schedules", fmt.Errorf("%s: %s", cfgSetting.Description, err))
+			continue
+		}
+
+		scheduleChanged := schedule.Ref != ref ||
+			schedule.Cron != cfgSetting.Cron ||
+			(cfgSetting.CronTimezone != "" && schedule.CronTimezone != cfgSetting.CronTimezone) ||
+			(cfgSetting.Active != nil && schedule.Active != *cfgSetting.Active)
+
+		if !scheduleChanged && len(scheduleVariableChanges(schedule, cfgSetting.Variables)) == 0 {
+			cfg.check(p, "Pipeline schedules", true, "")
+			cfg.printf("Project %s's %s Pipeline Schedule doesn't need updating\n", p.PathWithNamespace, cfgSetting.Description)
+			continue
+		}
+
+		cfg.check(p, "Pipeline schedules", false, fmt.Sprintf("%s: %s", cfgSetting.Description, scheduleChanges(schedule, cfgSetting, ref)))
+
+		cfg.printf("Project %s's %s Pipeline Schedule needs updating ... ", p.PathWithNamespace, cfgSetting.Description)
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			continue
+		}
+
+		audit, err := cfg.snapshot(p, "pipeline_schedule", cfgSetting.Description, true, schedule)
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		// Only the owner of a schedule can change it, so take ownership first if
+		// we're allowed to.
+		if cfgSetting.TakeOwnership {
+			currentUser, _, err := client.Users.CurrentUser()
+			if err != nil {
+				cfg.printf("Failed to fetch current user: %s\n", err)
+				cfg.updated(p, "Pipeline schedules", err)
+				continue
+			}
+			if schedule.Owner == nil || schedule.Owner.ID != currentUser.ID {
+				if _, _, err := client.PipelineSchedules.TakeOwnershipOfPipelineSchedule(p.ID, schedule.ID, audit); err != nil {
+					cfg.printf("Failed to take ownership of pipeline schedule: %s\n", err)
+					cfg.updated(p, "Pipeline schedules", err)
+					continue
+				}
+			}
+		}
+
+		if scheduleChanged {
+			opts := &gitlab.EditPipelineScheduleOptions{
+				Ref:    &ref,
+				Cron:   &cfgSetting.Cron,
+				Active: cfgSetting.Active,
+			}
+			if cfgSetting.CronTimezone != "" {
+				opts.CronTimezone = &cfgSetting.CronTimezone
+			}
+
+			if _, _, err := client.PipelineSchedules.EditPipelineSchedule(p.ID, schedule.ID, opts, audit); err != nil {
+				cfg.printf("Failed to update pipeline schedule: %s\n", err)
+				cfg.updated(p, "Pipeline schedules", err)
+				continue
+			}
+		}
+		if err := updateScheduleVariables(client, p.ID, schedule, cfgSetting.Variables, audit); err != nil {
+			cfg.printf("Failed to set pipeline schedule variables: %s\n", err)
+			cfg.updated(p, "Pipeline schedules", err)
+			continue
+		}
+		cfg.updated(p, "Pipeline schedules", nil)
+		cfg.printf("Success!\n")
+	}
+
+	return nil
+}
+
+// scheduleChanges returns a description of the attributes of a schedule that
+// differ from the config; variable values are left out as they may be secret.
+func scheduleChanges(schedule *gitlab.PipelineSchedule, cfgSetting *ScheduleSetting, ref string) string {
+	changes := []string{}
+	if schedule.Ref != ref {
+		changes = append(changes, fmt.Sprintf("ref: %s -> %s", schedule.Ref, ref))
+	}
+	if schedule.Cron != cfgSetting.Cron {
+		changes = append(changes, fmt.Sprintf("cron: %s -> %s", schedule.Cron, cfgSetting.Cron))
+	}
+	if cfgSetting.CronTimezone != "" && schedule.CronTimezone != cfgSetting.CronTimezone {
+		changes = append(changes, fmt.Sprintf("cron_timezone: %s -> %s", schedule.CronTimezone, cfgSetting.CronTimezone))
+	}
+	if cfgSetting.Active != nil && schedule.Active != *cfgSetting.Active {
+		changes = append(changes, fmt.Sprintf("active: %t -> %t", schedule.Active, *cfgSetting.Active))
+	}
+	for _, v := range scheduleVariableChanges(schedule, cfgSetting.Variables) {
+		changes = append(changes, fmt.Sprintf("variable %s: [redacted]", v.Key))
+	}
+
+	return strings.Join(changes, ", ")
+}
+
+// scheduleVariableChanges returns the variables of a schedule that need creating
+// or updating. Variables that aren't in the config are left alone.
+func scheduleVariableChanges(schedule *gitlab.PipelineSchedule, cfgVariables []*ScheduleVariableSetting) []*ScheduleVariableSetting {
+	changes := []*ScheduleVariableSetting{}
+	for _, v := range cfgVariables {
+		variableType := v.VariableType
+		if variableType == "" {
+			variableType = "env_var"
+		}
+
+		changed := true
+		for _, sv := range schedule.Variables {
+			if sv.Key == v.Key {
+				changed = sv.Value != v.Value || string(sv.VariableType) != variableType
+			}
+		}
+		if changed {
+			changes = append(changes, v)
+		}
+	}
+
+	return changes
+}
+
+// updateScheduleVariables creates or updates the variables of a schedule that
+// differ from the config.
+func updateScheduleVariables(client *gitlab.Client, pid int, schedule *gitlab.PipelineSchedule, cfgVariables []*ScheduleVariableSetting, audit gitlab.RequestOptionFunc) error {
+	for _, v := range scheduleVariableChanges(schedule, cfgVariables) {
+		var variableType *gitlab.VariableTypeValue
+		if v.VariableType != "" {
+			t := gitlab.VariableTypeValue(v.VariableType)
+			variableType = &t
+		}
+
+		exists := false
+		for _, sv := range schedule.Variables {
+			if sv.Key == v.Key {
+				exists = true
+			}
+		}
+
+		if exists {
+			opts := &gitlab.EditPipelineScheduleVariableOptions{
+				Value:        &v.Value,
+				VariableType: variableType,
+			}
+			if _, _, err := client.PipelineSchedules.EditPipelineScheduleVariable(pid, schedule.ID, v.Key, opts, audit); err != nil {
+				return err
+			}
+			continue
+		}
+
+		opts := &gitlab.CreatePipelineScheduleVariableOptions{
+			Key:          &v.Key,
+			Value:        &v.Value,
+			VariableType: variableType,
+		}
+		if _, _, err := client.PipelineSchedules.CreatePipelineScheduleVariable(pid, schedule.ID, opts, audit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restorePipelineSchedule restores a schedule and it's variables, or removes it
+// if it didn't exist. Taking ownership of a schedule can't be undone, so the
+// schedule remains owned by the user it was restored by.
+func restorePipelineSchedule(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.PipelineSchedule{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	if !s.Existed {
+		schedules, err := listPipelineSchedules(client, s.ProjectID)
+		if err != nil {
+			return err
+		}
+		for _, ps := range schedules {
+			if ps.Description == before.Description {
+				if _, err := client.PipelineSchedules.DeletePipelineSchedule(s.ProjectID, ps.ID, audit); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	opts := &gitlab.EditPipelineScheduleOptions{
+		Ref:          &before.Ref,
+		Cron:         &before.Cron,
+		CronTimezone: &before.CronTimezone,
+		Active:       &before.Active,
+	}
+	schedule, _, err := client.PipelineSchedules.EditPipelineSchedule(s.ProjectID, before.ID, opts, audit)
+	if err != nil {
+		return err
+	}
+
+	// Variables are only returned when fetching a single schedule.
+	schedule, _, err = client.PipelineSchedules.GetPipelineSchedule(s.ProjectID, schedule.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, sv := range schedule.Variables {
+		existed := false
+		for _, bv := range before.Variables {
+			if bv.Key == sv.Key {
+				existed = true
+			}
+		}
+		if !existed {
+			if _, _, err := client.PipelineSchedules.DeletePipelineScheduleVariable(s.ProjectID, schedule.ID, sv.Key, audit); err != nil {
+				return err
+			}
+		}
+	}
+
+	variables := []*ScheduleVariableSetting{}
+	for _, bv := range before.Variables {
+		variables = append(variables, &ScheduleVariableSetting{Key: bv.Key, Value: bv.Value, VariableType: string(bv.VariableType)})
+	}
+
+	return updateScheduleVariables(client, s.ProjectID, schedule, variables, audit)
+}
+
+// listPipelineSchedules returns a slice containing all pipeline schedules of a
+// project.
+func listPipelineSchedules(client *gitlab.Client, pid int) ([]*gitlab.PipelineSchedule, error) {
+	schedules := []*gitlab.PipelineSchedule{}
+
+	opt := &gitlab.ListPipelineSchedulesOptions{
+		PerPage: 100,
+		Page:    1,
+	}
+
+	for {
+		ss, resp, err := client.PipelineSchedules.ListPipelineSchedules(pid, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, ss...)
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		opt.Page = resp.NextPage
+	}
+
+	return schedules, nil
+}
@@ -0,0 +1,119 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestUpdateCISchedulesSettings(t *testing.T) {
+	list := `[{"id": 5, "description": "nightly"}]`
+	schedule := `{"id": 5, "description": "nightly", "ref": "main", "cron": "0 1 * * *", "cron_timezone": "UTC", "active": true,
+		"variables": [{"key": "TARGET", "value": "staging", "variable_type": "env_var"}]}`
+
+	tests := []struct {
+		name        string
+		schedules   string
+		cron        string
+		value       string
+		statuses    map[string]int
+		wantWrites  []string
+		wantDetails string
+	}{
+		{
+			name:       "matches",
+			schedules:  list,
+			cron:       "0 1 * * *",
+			value:      "staging",
+			wantWrites: []string{},
+		},
+		{
+			name:      "missing",
+			schedules: `[]`,
+			cron:      "0 1 * * *",
+			value:     "staging",
+			wantWrites: []string{
+				"POST /projects/1/pipeline_schedules",
+				"POST /projects/1/pipeline_schedules/5/variables",
+			},
+			wantDetails: "nightly is missing",
+		},
+		{
+			name:      "changed",
+			schedules: list,
+			cron:      "0 2 * * *",
+			value:     "production",
+			wantWrites: []string{
+				"PUT /projects/1/pipeline_schedules/5",
+				"PUT /projects/1/pipeline_schedules/5/variables/TARGET",
+			},
+			wantDetails: "nightly: cron: 0 1 * * * -> 0 2 * * *, variable TARGET: [redacted]",
+		},
+		{
+			name:        "schedule can't be fetched",
+			schedules:   list,
+			cron:        "0 1 * * *",
+			value:       "staging",
+			statuses:    map[string]int{"GET /projects/1/pipeline_schedules/5": http.StatusForbidden},
+			wantWrites:  []string{},
+			wantDetails: "failed to fetch current settings: nightly: ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client, cfg := newFakeGitLab(t, map[string]string{
+				"GET /projects/1/pipeline_schedules":                    tt.schedules,
+				"GET /projects/1/pipeline_schedules/5":                  schedule,
+				"POST /projects/1/pipeline_schedules":                   `{"id": 5, "description": "nightly"}`,
+				"PUT /projects/1/pipeline_schedules/5":                  schedule,
+				"POST /projects/1/pipeline_schedules/5/variables":       `{"key": "TARGET"}`,
+				"PUT /projects/1/pipeline_schedules/5/variables/TARGET": `{"key": "TARGET"}`,
+			})
+			for route, status := range tt.statuses {
+				f.statuses[route] = status
+			}
+			cfg.Groups[0].CI.Schedules = []*ScheduleSetting{{
+				Description: "nightly",
+				Cron:        tt.cron,
+				Variables:   []*ScheduleVariableSetting{{Key: "TARGET", Value: tt.value}},
+			}}
+			p := &gitlab.Project{ID: 1, PathWithNamespace: "acme/api", DefaultBranch: "main"}
+
+			if err := updateCISchedulesSettings(client, p, cfg); err != nil {
+				t.Fatalf("updateCISchedulesSettings() error = %v", err)
+			}
+
+			if got := f.writes(); !reflect.DeepEqual(got, tt.wantWrites) {
+				t.Errorf("writes = %v, want %v", got, tt.wantWrites)
+			}
+
+			check := onlyCheck(t, cfg, "Pipeline schedules")
+			if check.Compliant != (tt.wantDetails == "") || !strings.HasPrefix(check.Details, tt.wantDetails) {
+				t.Errorf("check = %+v, want details %q", check, tt.wantDetails)
+			}
+			if check.Updated != (len(tt.wantWrites) > 0) {
+				t.Errorf("check = %+v, want updated %t", check, len(tt.wantWrites) > 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// VariableSetting represents a CI/CD variable. Variables are identified by their
+// key and environment scope; their value is read from a secret source.
+type VariableSetting struct {
+	Key              string  `json:"key"`
+	EnvironmentScope string  `json:"environment_scope,omitempty"`
+	Value            *Secret `json:"value"`
+	VariableType     string  `json:"variable_type,omitempty"`
+	Masked           bool    `json:"masked"`
+	Protected        bool    `json:"protected"`
+	Raw              bool    `json:"raw"`
+}
+
+func updateCIVariablesSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch CI/CD variables from config file and return if a nil object is returned.
+	cfgSettings := cfg.CIVariablesSettings(p.PathWithNamespace)
+	if cfgSettings == nil {
+		return nil
+	}
+
+	// Fetch current CI/CD variables.
+	projectSettings, err := listProjectVariables(client, p.ID)
+	if err != nil {
+		cfg.fetchFailed(p, "CI/CD variables", err)
+		return nil
+	}
+
+	// Loop through variables mentioned in config file
+	for _, cfgSetting := range cfgSettings {
+		// Values aren't resolved for a report, so it can be made without access to them.
+		newSetting, err := cfgSetting.projectVariable(!cfg.report)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%s (%s)", newSetting.Key, newSetting.EnvironmentScope)
+
+		// Look for an existing variable; if nothing is found we'll create it.
+		var projectSetting *gitlab.ProjectVariable
+		for _, ps := range projectSettings {
+			if ps.Key == newSetting.Key && ps.EnvironmentScope == newSetting.EnvironmentScope {
+				projectSetting = ps
+			}
+		}
+
+		if projectSetting == nil {
+			cfg.check(p, "CI/CD variables", false, fmt.Sprintf("%s is missing", name))
+			cfg.printf("Project %s's CI/CD variable %s needs creating ... ", p.PathWithNamespace, name)
+
+			if cfg.DryRun {
+				cfg.printf("skipping because this is a dry run\n")
+				continue
+			}
+
+			opts := &gitlab.CreateProjectVariableOptions{
+				Key:              &newSetting.Key,
+				Value:            &newSetting.Value,
+				EnvironmentScope: &newSetting.EnvironmentScope,
+				Masked:           &newSetting.Masked,
+				Protected:        &newSetting.Protected,
+				Raw:              &newSetting.Raw,
+				VariableType:     &newSetting.VariableType,
+			}
+			before := &gitlab.ProjectVariable{Key: newSetting.Key, EnvironmentScope: newSetting.EnvironmentScope}
+			audit, err := cfg.snapshot(p, "ci_variable", name, false, before)
+			if err != nil {
+				return err
+			}
+
+			cfg.printf("Updating project ... ")
+
+			if _, _, err := client.ProjectVariables.CreateVariable(p.ID, opts, audit); err != nil {
+				cfg.printf("Failed to create variable: %s\n", err)
+				cfg.updated(p, "CI/CD variables", err)
+				continue
+			}
+			cfg.updated(p, "CI/CD variables", nil)
+			cfg.printf("Success!\n")
+
+			continue
+		}
+
+		// Only compare the attributes we manage.
+		newSetting.Description = projectSetting.Description
+		if cfg.report {
+			newSetting.Value = projectSetting.Value
+		}
+		if compareObjects(projectSetting, newSetting) {
+			if cfg.report {
+				cfg.unverifiable(p, "CI/CD variables", fmt.Sprintf("%s: value isn't read for a report", name))
+			} else {
+				cfg.check(p, "CI/CD variables", true, "")
+			}
+			cfg.printf("Project %s's CI/CD variable %s doesn't need updating\n", p.PathWithNamespace, name)
+			continue
+		}
+
+		cfg.check(p, "CI/CD variables", false, fmt.Sprintf("%s: %s", name, variableChanges(projectSetting, newSetting)))
+
+		cfg.printf("Project %s's CI/CD variable %s needs updating (%s) ... ", p.PathWithNamespace, name, variableChanges(projectSetting, newSetting))
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			continue
+		}
+
+		opts := &gitlab.UpdateProjectVariableOptions{
+			Value:        &newSetting.Value,
+			Filter:       &gitlab.VariableFilter{EnvironmentScope: newSetting.EnvironmentScope},
+			Masked:       &newSetting.Masked,
+			Protected:    &newSetting.Protected,
+			Raw:          &newSetting.Raw,
+			VariableType: &newSetting.VariableType,
+		}
+		audit, err := cfg.snapshot(p, "ci_variable", name, true, projectSetting)
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		if _, _, err := client.ProjectVariables.UpdateVariable(p.ID, newSetting.Key, opts, audit); err != nil {
+			cfg.printf("Failed to update variable: %s\n", err)
+			cfg.updated(p, "CI/CD variables", err)
+			continue
+		}
+		cfg.updated(p, "CI/CD variables", nil)
+		cfg.printf("Success!\n")
+	}
+
+	return nil
+}
+
+// restoreCIVariable restores a variable's value and attributes, or removes it if
+// it didn't exist.
+func restoreCIVariable(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.ProjectVariable{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+	filter := &gitlab.VariableFilter{EnvironmentScope: before.EnvironmentScope}
+
+	if !s.Existed {
+		_, err := client.ProjectVariables.RemoveVariable(s.ProjectID, before.Key, &gitlab.RemoveProjectVariableOptions{Filter: filter}, audit)
+		if err != nil && err.Error() != gitlab.ErrNotFound.Error() {
+			return err
+		}
+		return nil
+	}
+
+	opts := &gitlab.UpdateProjectVariableOptions{
+		Value:        &before.Value,
+		Filter:       filter,
+		Masked:       &before.Masked,
+		Protected:    &before.Protected,
+		Raw:          &before.Raw,
+		VariableType: &before.VariableType,
+	}
+	_, _, err := client.ProjectVariables.UpdateVariable(s.ProjectID, before.Key, opts, audit)
+
+	return err
+}
+
+// projectVariable returns the gitlab.ProjectVariable described by a variable
+// setting, reading it's value from it's secret source if resolve is true.
+func (v *VariableSetting) projectVariable(resolve bool) (*gitlab.ProjectVariable, error) {
+	if v.Key == "" {
+		return nil, fmt.Errorf("CI/CD variables must have a key")
+	}
+
+	var value string
+	if resolve {
+		var err error
+		if value, err = v.Value.Resolve(); err != nil {
+			return nil, fmt.Errorf("Cannot resolve value of CI/CD variable %s: %s", v.Key, err)
+		}
+	}
+
+	scope := v.EnvironmentScope
+	if scope == "" {
+		scope = "*"
+	}
+
+	variableType := gitlab.EnvVariableType
+	switch strings.ToLower(v.VariableType) {
+	case "", "env_var":
+	case "file":
+		variableType = gitlab.FileVariableType
+	default:
+		return nil, fmt.Errorf("Invalid variable type for CI/CD variable %s: %s", v.Key, v.VariableType)
+	}
+
+	return &gitlab.ProjectVariable{
+		Key:              v.Key,
+		Value:            value,
+		VariableType:     variableType,
+		Protected:        v.Protected,
+		Masked:           v.Masked,
+		Raw:              v.Raw,
+		EnvironmentScope: scope,
+	}, nil
+}
+
+// variableChanges describes the differences between two variables; values are
+// redacted so they never appear in the output.
+func variableChanges(a, b *gitlab.ProjectVariable) string {
+	changes := []string{}
+	if a.Value != b.Value {
+		changes = append(changes, "value: [redacted]")
+	}
+	if a.VariableType != b.VariableType {
+		changes = append(changes, fmt.Sprintf("variable_type: %s -> %s", a.VariableType, b.VariableType))
+	}
+	if a.Protected != b.Protected {
+		changes = append(changes, fmt.Sprintf("protected: %t -> %t", a.Protected, b.Protected))
+	}
+	if a.Masked != b.Masked {
+		changes = append(changes, fmt.Sprintf("masked: %t -> %t", a.Masked, b.Masked))
+	}
+	if a.Raw != b.Raw {
+		changes = append(changes, fmt.Sprintf("raw: %t -> %t", a.Raw, b.Raw))
+	}
+
+	return strings.Join(changes, ", ")
+}
+
+// listProjectVariables returns a slice containing all of a project's CI/CD variables.
+func listProjectVariables(client *gitlab.Client, pid int) ([]*gitlab.ProjectVariable, error) {
+	variables := []*gitlab.ProjectVariable{}
+
+	opt := &gitlab.ListProjectVariablesOptions{
+		PerPage: 100,
+		Page:    1,
+	}
+
+	for {
+		vs, resp, err := client.ProjectVariables.ListVariables(pid, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		variables = append(variables, vs...)
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		opt.Page = resp.NextPage
+	}
+
+	return variables, nil
+}
@@ -0,0 +1,113 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdateCIVariablesSettings(t *testing.T) {
+	current := `[{"key": "TOKEN", "value": "s3cr3t", "variable_type": "env_var", "environment_scope": "*", "masked": true}]`
+
+	tests := []struct {
+		name             string
+		variables        string
+		value            string
+		report           bool
+		wantWrites       []string
+		wantDetails      string
+		wantUnverifiable bool
+	}{
+		{
+			name:       "matches",
+			variables:  current,
+			value:      "s3cr3t",
+			wantWrites: []string{},
+		},
+		{
+			name:        "value changed",
+			variables:   current,
+			value:       "n3w",
+			wantWrites:  []string{"PUT /projects/1/variables/TOKEN"},
+			wantDetails: "TOKEN (*): value: [redacted]",
+		},
+		{
+			name:        "missing",
+			variables:   `[]`,
+			value:       "s3cr3t",
+			wantWrites:  []string{"POST /projects/1/variables"},
+			wantDetails: "TOKEN (*) is missing",
+		},
+		{
+			// Values aren't read for a report, so the secret doesn't need to exist.
+			name:             "report",
+			variables:        current,
+			report:           true,
+			wantWrites:       []string{},
+			wantUnverifiable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client, cfg := newFakeGitLab(t, map[string]string{
+				"GET /projects/1/variables":       tt.variables,
+				"POST /projects/1/variables":      `{"key": "TOKEN"}`,
+				"PUT /projects/1/variables/TOKEN": `{"key": "TOKEN"}`,
+			})
+			cfg.report = tt.report
+			cfg.DryRun = tt.report
+
+			secret := filepath.Join(t.TempDir(), "token")
+			if !tt.report {
+				if err := ioutil.WriteFile(secret, []byte(tt.value+"\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg.Groups[0].CI.Variables = []*VariableSetting{{Key: "TOKEN", Value: &Secret{File: secret}, Masked: true}}
+
+			if err := updateCIVariablesSettings(client, testProject, cfg); err != nil {
+				t.Fatalf("updateCIVariablesSettings() error = %v", err)
+			}
+
+			if got := f.writes(); !reflect.DeepEqual(got, tt.wantWrites) {
+				t.Fatalf("writes = %v, want %v", got, tt.wantWrites)
+			}
+
+			check := onlyCheck(t, cfg, "CI/CD variables")
+			if check.Compliant != (tt.wantDetails == "") || check.Unverifiable != tt.wantUnverifiable {
+				t.Errorf("check = %+v, want compliant %t and unverifiable %t", check, tt.wantDetails == "", tt.wantUnverifiable)
+			}
+			if tt.wantDetails != "" && check.Details != tt.wantDetails {
+				t.Errorf("check details = %q, want %q", check.Details, tt.wantDetails)
+			}
+			if strings.Contains(check.Details, "s3cr3t") {
+				t.Errorf("check details %q hold the variable's value", check.Details)
+			}
+
+			if len(tt.wantWrites) > 0 {
+				if body := f.request(t, tt.wantWrites[0]).Body; !strings.Contains(body, `"value":"`+tt.value+`"`) {
+					t.Errorf("request body %s doesn't set the value", body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/imdario/mergo"
+	"github.com/jinzhu/copier"
+	"github.com/xanzy/go-gitlab"
+)
+
+// ContainerExpirationPolicySettings represents a project's container registry
+// cleanup policy.
+type ContainerExpirationPolicySettings struct {
+	Enabled         *bool  `json:"enabled,omitempty"`
+	Cadence         string `json:"cadence,omitempty"`
+	KeepN           int    `json:"keep_n,omitempty"`
+	OlderThan       string `json:"older_than,omitempty"`
+	NameRegexKeep   string `json:"name_regex_keep,omitempty"`
+	NameRegexDelete string `json:"name_regex_delete,omitempty"`
+}
+
+func updateContainerExpirationPolicySettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch the cleanup policy from config file and return if a nil object is returned.
+	cfgSettings := cfg.ContainerExpirationPolicySettings(p.PathWithNamespace)
+	if cfgSettings == nil || compareObjects(cfgSettings, &ContainerExpirationPolicySettings{}) {
+		return nil
+	}
+	if err := cfgSettings.validate(); err != nil {
+		return err
+	}
+
+	// Fetch current cleanup policy.
+	project, _, err := client.Projects.GetProject(p.ID, nil)
+	if err != nil {
+		cfg.fetchFailed(p, "Container Registry cleanup policy", err)
+		return nil
+	}
+	projectSettings := project.ContainerExpirationPolicy
+	if projectSettings == nil {
+		projectSettings = &gitlab.ContainerExpirationPolicy{}
+	}
+
+	// Take what is current and apply our changes on top, thus we do not override any
+	// settings that we don't define in our config.
+	newSettings := &gitlab.ContainerExpirationPolicy{}
+	if err := copier.Copy(newSettings, projectSettings); err != nil {
+		return err
+	}
+
+	cfgPolicy := &gitlab.ContainerExpirationPolicy{
+		Cadence:         cfgSettings.Cadence,
+		KeepN:           cfgSettings.KeepN,
+		OlderThan:       cfgSettings.OlderThan,
+		NameRegexKeep:   cfgSettings.NameRegexKeep,
+		NameRegexDelete: cfgSettings.NameRegexDelete,
+	}
+	if err := mergo.Merge(newSettings, cfgPolicy, mergo.WithOverride); err != nil {
+		return err
+	}
+	// Booleans are set separately as mergo won't override a value with false.
+	if cfgSettings.Enabled != nil {
+		newSettings.Enabled = *cfgSettings.Enabled
+	}
+	// NameRegex is deprecated and hardwired to NameRegexDelete by GitLab.
+	if cfgSettings.NameRegexDelete != "" {
+		newSettings.NameRegex = cfgSettings.NameRegexDelete
+	}
+
+	// Return if our proposed config matches the actual config
+	if compareObjects(projectSettings, newSettings) {
+		cfg.check(p, "Container Registry cleanup policy", true, "")
+		cfg.printf("Project %s's Container Registry cleanup policy doesn't need updating\n", p.PathWithNamespace)
+		return nil
+	}
+
+	cfg.check(p, "Container Registry cleanup policy", false, diffObjects(projectSettings, newSettings))
+
+	cfg.printf("Project %s's Container Registry cleanup policy needs updating ... ", p.PathWithNamespace)
+
+	if cfg.DryRun {
+		cfg.printf("skipping because this is a dry run\n")
+		return nil
+	}
+
+	attrs := &gitlab.ContainerExpirationPolicyAttributes{}
+
+	policyData, _ := json.Marshal(newSettings)
+	if err := json.Unmarshal(policyData, &attrs); err != nil {
+		return err
+	}
+	audit, err := cfg.snapshot(p, "container_expiration_policy", "", true, projectSettings)
+	if err != nil {
+		return err
+	}
+
+	cfg.printf("Updating project ... ")
+
+	_, _, err = client.Projects.EditProject(p.ID, &gitlab.EditProjectOptions{ContainerExpirationPolicyAttributes: attrs}, audit)
+	if err != nil {
+		cfg.updated(p, "Container Registry cleanup policy", err)
+		return err
+	}
+	cfg.updated(p, "Container Registry cleanup policy", nil)
+	cfg.printf("Success!\n")
+
+	return nil
+}
+
+// restoreContainerExpirationPolicy restores a project's cleanup policy.
+func restoreContainerExpirationPolicy(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	attrs := &gitlab.ContainerExpirationPolicyAttributes{}
+	if err := json.Unmarshal(s.Before, attrs); err != nil {
+		return err
+	}
+
+	_, _, err := client.Projects.EditProject(s.ProjectID, &gitlab.EditProjectOptions{ContainerExpirationPolicyAttributes: attrs}, audit)
+
+	return err
+}
+
+// validate checks the cleanup policy only uses values supported by GitLab.
+func (c *ContainerExpirationPolicySettings) validate() error {
+	if c.Cadence != "" && !contains([]string{"1d", "7d", "14d", "1month", "3month"}, c.Cadence) {
+		return fmt.Errorf("Invalid container expiration policy cadence: %s", c.Cadence)
+	}
+	if c.KeepN != 0 && !contains([]string{"1", "5", "10", "25", "50", "100"}, strconv.Itoa(c.KeepN)) {
+		return fmt.Errorf("Invalid container expiration policy keep_n: %d", c.KeepN)
+	}
+	if c.OlderThan != "" && !contains([]string{"7d", "14d", "30d", "90d"}, c.OlderThan) {
+		return fmt.Errorf("Invalid container expiration policy older_than: %s", c.OlderThan)
+	}
+
+	return nil
+}
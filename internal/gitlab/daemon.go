@@ -0,0 +1,243 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// configPollInterval is how often the daemon checks if the config file changed.
+const configPollInterval = 10 * time.Second
+
+// Daemon reconciles the projects in the configured groups on an interval. It
+// keeps the result of each setting of each project from the last run, and only
+// logs the settings whose result changed since, along with every update that
+// failed.
+type Daemon struct {
+	// Interval is the time between runs, and Jitter the maximum random time
+	// added to it so runs of several daemons don't line up.
+	Interval time.Duration
+	Jitter   time.Duration
+
+	// ConfigFile is watched for changes, and Load is called to load the config
+	// at start and whenever it changes.
+	ConfigFile string
+	Load       func() (*Config, error)
+
+	// pending holds a reloaded config until the next run starts, so the config
+	// isn't swapped while a run is using it. lastRun is when the last run
+	// finished, and lastSuccess when the last successful run did.
+	mu          sync.Mutex
+	cfg         *Config
+	pending     *Config
+	started     time.Time
+	lastRun     time.Time
+	lastSuccess time.Time
+	lastErr     error
+	results     map[string]*Check
+	reloaded    chan struct{}
+}
+
+// Run loads the config and reconciles the projects in the configured groups until
+// the process exits. A run is started right away when the config file changes.
+func (d *Daemon) Run() error {
+	cfg, err := d.Load()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.cfg = cfg
+	d.started = time.Now()
+	d.reloaded = make(chan struct{}, 1)
+	d.mu.Unlock()
+
+	go d.watchConfig()
+
+	rand.Seed(time.Now().UnixNano())
+	log.Printf("Reconciling every %s with up to %s jitter\n", d.Interval, d.Jitter)
+
+	for {
+		d.reconcile()
+
+		wait := d.Interval
+		if d.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(d.Jitter)))
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-d.reloaded:
+		}
+	}
+}
+
+// reconcile runs the settings against all projects and logs the results that
+// changed since the last run. A reloaded config is applied before the run starts.
+func (d *Daemon) reconcile() {
+	d.mu.Lock()
+	if d.pending != nil {
+		if c, ok := d.cfg.auditLog.(io.Closer); ok {
+			c.Close()
+		}
+		d.cfg = d.pending
+		d.pending = nil
+		log.Printf("Applied reloaded config file %s\n", d.ConfigFile)
+	}
+	cfg := d.cfg
+	d.mu.Unlock()
+
+	// Each run gets a run ID of it's own so it can be rolled back.
+	cfg.RunID = NewRunID()
+	cfg.checks = nil
+
+	// The update handlers print everything they check, so their output is
+	// discarded and only changes in the results are logged.
+	cfg.Output = ioutil.Discard
+	cfg.unattended = true
+	err := UpdateProjectsInGroups(cfg)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lastRun = time.Now()
+	if err != nil {
+		if d.lastErr == nil || d.lastErr.Error() != err.Error() {
+			log.Printf("Run %s failed: %s\n", cfg.RunID, err)
+		}
+		d.lastErr = err
+		return
+	}
+	if d.lastErr != nil {
+		log.Printf("Run %s succeeded after previous runs failed\n", cfg.RunID)
+	}
+	d.lastErr = nil
+	d.lastSuccess = d.lastRun
+
+	_, _, cells := reportMatrix(cfg.checks)
+	results := map[string]*Check{}
+	for project, settings := range cells {
+		for setting, c := range settings {
+			key := project + "\x00" + setting
+			results[key] = c
+
+			// Failed updates are logged on every run, as they need looking into.
+			if c.Error != "" {
+				log.Printf("Project %s's %s settings drifted from the config and failed to update in run %s: %s: %s\n", project, setting, cfg.RunID, c.Details, c.Error)
+				continue
+			}
+
+			prev, seen := d.results[key]
+			if seen && prev.Error == "" && cellText(prev) == cellText(c) {
+				continue
+			}
+
+			switch {
+			case !c.Compliant && c.Updated:
+				log.Printf("Project %s's %s settings drifted from the config and were updated in run %s: %s\n", project, setting, cfg.RunID, c.Details)
+			case !c.Compliant:
+				log.Printf("Project %s's %s settings drifted from the config: %s\n", project, setting, c.Details)
+			case seen && (cfg.DryRun || prev.Error != ""):
+				// Outside a dry run the settings were updated by the previous run, so
+				// them matching the config now is only news if that update failed.
+				log.Printf("Project %s's %s settings match the config again\n", project, setting)
+			}
+		}
+	}
+	d.results = results
+}
+
+// watchConfig polls the config file, and reloads the config whenever it changes.
+// If the changed config can't be loaded the current config is kept. A reloaded
+// config is applied by the next run, which is started right away unless a run is
+// already in progress.
+func (d *Daemon) watchConfig() {
+	var modTime time.Time
+	if fi, err := os.Stat(d.ConfigFile); err == nil {
+		modTime = fi.ModTime()
+	}
+
+	for range time.Tick(configPollInterval) {
+		fi, err := os.Stat(d.ConfigFile)
+		if err != nil || fi.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = fi.ModTime()
+
+		cfg, err := d.Load()
+		if err != nil {
+			log.Printf("Failed to reload config file %s, keeping the current config: %s\n", d.ConfigFile, err)
+			continue
+		}
+
+		d.mu.Lock()
+		d.pending = cfg
+		d.mu.Unlock()
+		log.Printf("Reloaded config file %s\n", d.ConfigFile)
+
+		select {
+		case d.reloaded <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Handler returns an http.Handler serving the daemon's health and readiness
+// endpoints. The daemon is healthy as long as runs keep finishing, whether they
+// succeed or not, and ready once it's last run succeeded.
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		since := d.started
+		if d.lastRun.After(since) {
+			since = d.lastRun
+		}
+		d.mu.Unlock()
+
+		if !since.IsZero() && time.Since(since) > 2*(d.Interval+d.Jitter) {
+			http.Error(w, fmt.Sprintf("No run has finished since %s", since.Format(time.RFC3339)), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		lastSuccess, lastErr := d.lastSuccess, d.lastErr
+		d.mu.Unlock()
+
+		switch {
+		case lastErr != nil:
+			http.Error(w, fmt.Sprintf("Last run failed: %s", lastErr), http.StatusServiceUnavailable)
+		case lastSuccess.IsZero():
+			http.Error(w, "Waiting for the first run to finish", http.StatusServiceUnavailable)
+		default:
+			fmt.Fprintln(w, "ok")
+		}
+	})
+
+	return mux
+}
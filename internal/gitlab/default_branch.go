@@ -0,0 +1,161 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+func updateDefaultBranchSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch the default branch from config file and return if it isn't set.
+	cfgSetting := cfg.DefaultBranchSettings(p.PathWithNamespace)
+	if cfgSetting == "" {
+		return nil
+	}
+
+	if p.DefaultBranch == cfgSetting {
+		cfg.check(p, "Default Branch", true, "")
+		cfg.printf("Project %s's Default Branch doesn't need updating\n", p.PathWithNamespace)
+		return nil
+	}
+
+	// We can only switch to a branch that exists; projects without it are reported
+	// rather than treated as an error so the remaining projects are still updated.
+	_, resp, err := client.Branches.GetBranch(p.ID, cfgSetting)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			cfg.check(p, "Default Branch", false, fmt.Sprintf("%s -> %s, which can't be set as the branch doesn't exist", p.DefaultBranch, cfgSetting))
+			cfg.printf("Project %s's Default Branch can't be set as branch %s doesn't exist, skipping\n", p.PathWithNamespace, cfgSetting)
+			return nil
+		}
+		cfg.fetchFailed(p, "Default Branch", err)
+		return nil
+	}
+
+	cfg.check(p, "Default Branch", false, fmt.Sprintf("%s -> %s", p.DefaultBranch, cfgSetting))
+
+	cfg.printf("Project %s's Default Branch needs updating from %s to %s ... ", p.PathWithNamespace, p.DefaultBranch, cfgSetting)
+
+	if cfg.DryRun {
+		cfg.printf("skipping because this is a dry run\n")
+		return nil
+	}
+
+	before := map[string]interface{}{"default_branch": p.DefaultBranch}
+	audit, err := cfg.snapshot(p, "project", "default branch", true, before)
+	if err != nil {
+		return err
+	}
+
+	cfg.printf("Updating project ... ")
+
+	_, _, err = client.Projects.EditProject(p.ID, &gitlab.EditProjectOptions{DefaultBranch: &cfgSetting}, audit)
+	if err != nil {
+		cfg.updated(p, "Default Branch", err)
+		return err
+	}
+	cfg.updated(p, "Default Branch", nil)
+	cfg.printf("Success!\n")
+
+	oldBranch := p.DefaultBranch
+	p.DefaultBranch = cfgSetting
+
+	return copyBranchProtection(client, p, cfg, oldBranch, cfgSetting)
+}
+
+// copyBranchProtection protects a branch using the same settings as another, so
+// protections follow a default branch when it's renamed. The old branch's
+// protection is left in place until the branch is removed. Failures are recorded
+// on the default branch's check, as the default branch has already been changed.
+func copyBranchProtection(client *gitlab.Client, p *gitlab.Project, cfg *Config, from, to string) error {
+	if from == "" {
+		return nil
+	}
+
+	oldSetting, resp, err := client.ProtectedBranches.GetProtectedBranch(p.ID, from)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		cfg.printf("Failed to fetch project %s's %s Branch Protection settings: %s\n", p.PathWithNamespace, from, err)
+		cfg.updated(p, "Default Branch", fmt.Errorf("failed to fetch %s's branch protection: %s", from, err))
+		return nil
+	}
+
+	_, resp, err = client.ProtectedBranches.GetProtectedBranch(p.ID, to)
+	if err == nil {
+		// The new branch is already protected, so leave it as it is.
+		return nil
+	}
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		cfg.printf("Failed to fetch project %s's %s Branch Protection settings: %s\n", p.PathWithNamespace, to, err)
+		cfg.updated(p, "Default Branch", fmt.Errorf("failed to fetch %s's branch protection: %s", to, err))
+		return nil
+	}
+
+	cfg.printf("Project %s's %s Branch Protection settings need copying to %s ... ", p.PathWithNamespace, from, to)
+
+	setOpts := &gitlab.ProtectRepositoryBranchesOptions{
+		Name:                      &to,
+		AllowForcePush:            &oldSetting.AllowForcePush,
+		AllowedToPush:             branchPermissions(oldSetting.PushAccessLevels),
+		AllowedToMerge:            branchPermissions(oldSetting.MergeAccessLevels),
+		AllowedToUnprotect:        branchPermissions(oldSetting.UnprotectAccessLevels),
+		CodeOwnerApprovalRequired: &oldSetting.CodeOwnerApprovalRequired,
+	}
+	audit, err := cfg.snapshot(p, "protected_branch", to, false, &gitlab.ProtectedBranch{Name: to})
+	if err != nil {
+		return err
+	}
+
+	cfg.printf("Updating project ... ")
+
+	_, _, err = client.ProtectedBranches.ProtectRepositoryBranches(p.ID, setOpts, audit)
+	if err != nil {
+		cfg.printf("Failed to protect branch: %s\n", err)
+		cfg.updated(p, "Default Branch", err)
+		return nil
+	}
+	cfg.printf("Success!\n")
+
+	return nil
+}
+
+// branchPermissions converts a protected branch's access levels into the options
+// needed to protect another branch with the same access levels.
+func branchPermissions(ds []*gitlab.BranchAccessDescription) *[]*gitlab.BranchPermissionOptions {
+	perms := []*gitlab.BranchPermissionOptions{}
+	for _, d := range ds {
+		perm := &gitlab.BranchPermissionOptions{}
+		switch {
+		case d.UserID != 0:
+			perm.UserID = &d.UserID
+		case d.GroupID != 0:
+			perm.GroupID = &d.GroupID
+		case d.DeployKeyID != 0:
+			perm.DeployKeyID = &d.DeployKeyID
+		default:
+			perm.AccessLevel = &d.AccessLevel
+		}
+		perms = append(perms, perm)
+	}
+
+	return &perms
+}
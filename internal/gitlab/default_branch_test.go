@@ -0,0 +1,115 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestUpdateDefaultBranchSettings(t *testing.T) {
+	protected := `{"name": "main", "push_access_levels": [{"access_level": 40}], "merge_access_levels": [{"access_level": 30}]}`
+
+	tests := []struct {
+		name        string
+		current     string
+		responses   map[string]string
+		statuses    map[string]int
+		wantWrites  []string
+		wantDetails string
+		wantUpdated bool
+		wantError   string
+	}{
+		{
+			name:       "matches",
+			current:    "develop",
+			wantWrites: []string{},
+		},
+		{
+			name:        "branch missing",
+			current:     "main",
+			wantWrites:  []string{},
+			wantDetails: "main -> develop, which can't be set as the branch doesn't exist",
+		},
+		{
+			name:        "branch can't be fetched",
+			current:     "main",
+			responses:   map[string]string{"GET /projects/1/repository/branches/develop": `{"message":"403 Forbidden"}`},
+			statuses:    map[string]int{"GET /projects/1/repository/branches/develop": http.StatusForbidden},
+			wantWrites:  []string{},
+			wantDetails: "failed to fetch current settings",
+		},
+		{
+			name:    "protection copied",
+			current: "main",
+			responses: map[string]string{
+				"GET /projects/1/repository/branches/develop": `{"name": "develop"}`,
+				"PUT /projects/1":                         `{"id": 1}`,
+				"GET /projects/1/protected_branches/main": protected,
+				"POST /projects/1/protected_branches":     `{"name": "develop"}`,
+			},
+			wantWrites:  []string{"PUT /projects/1", "POST /projects/1/protected_branches"},
+			wantDetails: "main -> develop",
+			wantUpdated: true,
+		},
+		{
+			name:    "protection can't be fetched",
+			current: "main",
+			responses: map[string]string{
+				"GET /projects/1/repository/branches/develop": `{"name": "develop"}`,
+				"PUT /projects/1":                         `{"id": 1}`,
+				"GET /projects/1/protected_branches/main": `{"message":"403 Forbidden"}`,
+			},
+			statuses:    map[string]int{"GET /projects/1/protected_branches/main": http.StatusForbidden},
+			wantWrites:  []string{"PUT /projects/1"},
+			wantDetails: "main -> develop",
+			wantUpdated: true,
+			wantError:   "failed to fetch main's branch protection",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client, cfg := newFakeGitLab(t, tt.responses)
+			for route, status := range tt.statuses {
+				f.statuses[route] = status
+			}
+			cfg.Groups[0].Repository.DefaultBranch = "develop"
+			p := &gitlab.Project{ID: 1, PathWithNamespace: "acme/api", DefaultBranch: tt.current}
+
+			if err := updateDefaultBranchSettings(client, p, cfg); err != nil {
+				t.Fatalf("updateDefaultBranchSettings() error = %v", err)
+			}
+
+			if got := f.writes(); !reflect.DeepEqual(got, tt.wantWrites) {
+				t.Fatalf("writes = %v, want %v", got, tt.wantWrites)
+			}
+
+			check := onlyCheck(t, cfg, "Default Branch")
+			if check.Compliant != (tt.wantDetails == "") || !strings.HasPrefix(check.Details, tt.wantDetails) {
+				t.Errorf("check = %+v, want details %q", check, tt.wantDetails)
+			}
+			if check.Updated != tt.wantUpdated || !strings.HasPrefix(check.Error, tt.wantError) {
+				t.Errorf("check = %+v, want updated %t and error %q", check, tt.wantUpdated, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// DeployKeySetting represents a deploy key. Shared keys that already exist in
+// GitLab are enabled by ID, while other keys are created from a local public key
+// file.
+type DeployKeySetting struct {
+	ID      int    `json:"id,omitempty"`
+	Title   string `json:"title,omitempty"`
+	KeyFile string `json:"key_file,omitempty"`
+	CanPush *bool  `json:"can_push,omitempty"`
+}
+
+func updateDeployKeysSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch deploy keys from config file and return if a nil object is returned.
+	cfgSettings := cfg.DeployKeysSettings(p.PathWithNamespace)
+	if cfgSettings == nil {
+		return nil
+	}
+
+	// Fetch current project deploy keys.
+	projectSettings, err := listProjectDeployKeys(client, p.ID)
+	if err != nil {
+		cfg.fetchFailed(p, "Deploy keys", err)
+		return nil
+	}
+
+	// Loop through deploy keys mentioned in config file
+	for _, cfgSetting := range cfgSettings {
+		if cfgSetting.ID == 0 && (cfgSetting.Title == "" || cfgSetting.KeyFile == "") {
+			return fmt.Errorf("Deploy keys must have either an ID or a title and key file")
+		}
+
+		var key string
+		if cfgSetting.ID == 0 {
+			content, err := readLocalFile(cfgSetting.KeyFile)
+			if err != nil {
+				return err
+			}
+			key = strings.TrimSpace(content)
+		}
+
+		// Look for an existing deploy key, by ID for shared keys and by public key
+		// otherwise.
+		var projectSetting *gitlab.ProjectDeployKey
+		for _, ps := range projectSettings {
+			if (cfgSetting.ID != 0 && ps.ID == cfgSetting.ID) ||
+				(cfgSetting.ID == 0 && publicKey(ps.Key) == publicKey(key)) {
+				projectSetting = ps
+			}
+		}
+
+		name := cfgSetting.Title
+		if cfgSetting.ID != 0 {
+			name = fmt.Sprintf("#%d", cfgSetting.ID)
+		}
+
+		if projectSetting == nil {
+			action := "creating"
+			if cfgSetting.ID != 0 {
+				action = "enabling"
+			}
+			cfg.check(p, "Deploy keys", false, fmt.Sprintf("%s is missing", name))
+			cfg.printf("Project %s's %s Deploy Key needs %s ... ", p.PathWithNamespace, name, action)
+
+			if cfg.DryRun {
+				cfg.printf("skipping because this is a dry run\n")
+				continue
+			}
+
+			before := &gitlab.ProjectDeployKey{ID: cfgSetting.ID, Title: cfgSetting.Title, Key: key}
+			audit, err := cfg.snapshot(p, "deploy_key", name, false, before)
+			if err != nil {
+				return err
+			}
+
+			cfg.printf("Updating project ... ")
+
+			if cfgSetting.ID != 0 {
+				projectSetting, _, err = client.DeployKeys.EnableDeployKey(p.ID, cfgSetting.ID, audit)
+			} else {
+				opts := &gitlab.AddDeployKeyOptions{
+					Title:   &cfgSetting.Title,
+					Key:     &key,
+					CanPush: cfgSetting.CanPush,
+				}
+				projectSetting, _, err = client.DeployKeys.AddDeployKey(p.ID, opts, audit)
+			}
+			if err != nil {
+				cfg.printf("Failed to add deploy key: %s\n", err)
+				cfg.updated(p, "Deploy keys", err)
+				continue
+			}
+			cfg.updated(p, "Deploy keys", nil)
+			cfg.printf("Success!\n")
+
+			// Shared keys are enabled read-only, so write access is set separately.
+			if cfgSetting.ID == 0 {
+				continue
+			}
+		}
+
+		// The title of a shared key is owned by the key, so it's only managed for
+		// keys created by us.
+		if (cfgSetting.ID != 0 || projectSetting.Title == cfgSetting.Title) &&
+			(cfgSetting.CanPush == nil || projectSetting.CanPush == *cfgSetting.CanPush) {
+			cfg.check(p, "Deploy keys", true, "")
+			cfg.printf("Project %s's %s Deploy Key doesn't need updating\n", p.PathWithNamespace, name)
+			continue
+		}
+
+		changes := []string{}
+		if cfgSetting.ID == 0 && projectSetting.Title != cfgSetting.Title {
+			changes = append(changes, fmt.Sprintf("title: %s -> %s", projectSetting.Title, cfgSetting.Title))
+		}
+		if cfgSetting.CanPush != nil && projectSetting.CanPush != *cfgSetting.CanPush {
+			changes = append(changes, fmt.Sprintf("can_push: %t -> %t", projectSetting.CanPush, *cfgSetting.CanPush))
+		}
+		cfg.check(p, "Deploy keys", false, fmt.Sprintf("%s: %s", name, strings.Join(changes, ", ")))
+
+		cfg.printf("Project %s's %s Deploy Key needs updating ... ", p.PathWithNamespace, name)
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			continue
+		}
+
+		opts := &gitlab.UpdateDeployKeyOptions{
+			CanPush: cfgSetting.CanPush,
+		}
+		if cfgSetting.ID == 0 {
+			opts.Title = &cfgSetting.Title
+		}
+		audit, err := cfg.snapshot(p, "deploy_key", name, true, projectSetting)
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		if _, _, err := client.DeployKeys.UpdateDeployKey(p.ID, projectSetting.ID, opts, audit); err != nil {
+			cfg.printf("Failed to update deploy key: %s\n", err)
+			cfg.updated(p, "Deploy keys", err)
+			continue
+		}
+		cfg.updated(p, "Deploy keys", nil)
+		cfg.printf("Success!\n")
+	}
+
+	return nil
+}
+
+// restoreDeployKey restores a deploy key's title and write access, or removes it
+// from the project if it wasn't enabled.
+func restoreDeployKey(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.ProjectDeployKey{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	if s.Existed {
+		opts := &gitlab.UpdateDeployKeyOptions{Title: &before.Title, CanPush: &before.CanPush}
+		_, _, err := client.DeployKeys.UpdateDeployKey(s.ProjectID, before.ID, opts, audit)
+		return err
+	}
+
+	projectSettings, err := listProjectDeployKeys(client, s.ProjectID)
+	if err != nil {
+		return err
+	}
+	for _, ps := range projectSettings {
+		if (before.ID != 0 && ps.ID == before.ID) ||
+			(before.ID == 0 && publicKey(ps.Key) == publicKey(before.Key)) {
+			if _, err := client.DeployKeys.DeleteDeployKey(s.ProjectID, ps.ID, audit); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// publicKey returns the type and key of an SSH public key, leaving out the
+// comment so keys can be matched regardless of it.
+func publicKey(key string) string {
+	fields := strings.Fields(key)
+	if len(fields) > 2 {
+		fields = fields[:2]
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// listProjectDeployKeys returns a slice containing all deploy keys enabled in a
+// project.
+func listProjectDeployKeys(client *gitlab.Client, pid int) ([]*gitlab.ProjectDeployKey, error) {
+	keys := []*gitlab.ProjectDeployKey{}
+
+	opt := &gitlab.ListProjectDeployKeysOptions{
+		PerPage: 100,
+		Page:    1,
+	}
+
+	for {
+		ks, resp, err := client.DeployKeys.ListProjectDeployKeys(pid, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, ks...)
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		opt.Page = resp.NextPage
+	}
+
+	return keys, nil
+}
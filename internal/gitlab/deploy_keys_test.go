@@ -0,0 +1,88 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateDeployKeysSettings(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := func(name, key string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	f, client, cfg := newFakeGitLab(t, map[string]string{
+		"GET /projects/1/deploy_keys": `[
+			{"id": 10, "title": "ci", "key": "ssh-ed25519 AAAAci ci@host", "can_push": false},
+			{"id": 20, "title": "shared", "key": "ssh-ed25519 AAAAshared", "can_push": false}
+		]`,
+		"POST /projects/1/deploy_keys":           `{"id": 11, "title": "deploy", "key": "ssh-ed25519 AAAAdeploy"}`,
+		"POST /projects/1/deploy_keys/30/enable": `{"id": 30, "title": "mirror", "key": "ssh-ed25519 AAAAmirror", "can_push": false}`,
+		"PUT /projects/1/deploy_keys/20":         `{"id": 20}`,
+		"PUT /projects/1/deploy_keys/30":         `{"id": 30}`,
+	})
+	canPush := true
+	cfg.Groups[0].Repository.DeployKeys = []*DeployKeySetting{
+		// Keys are matched regardless of their comment.
+		{Title: "ci", KeyFile: keyFile("ci.pub", "ssh-ed25519 AAAAci someone@laptop")},
+		{Title: "deploy", KeyFile: keyFile("deploy.pub", "ssh-ed25519 AAAAdeploy")},
+		{ID: 20, CanPush: &canPush},
+		// Shared keys are enabled read-only, so write access is set afterwards.
+		{ID: 30, CanPush: &canPush},
+	}
+
+	if err := updateDeployKeysSettings(client, testProject, cfg); err != nil {
+		t.Fatalf("updateDeployKeysSettings() error = %v", err)
+	}
+
+	wantWrites := []string{
+		"POST /projects/1/deploy_keys",
+		"PUT /projects/1/deploy_keys/20",
+		"POST /projects/1/deploy_keys/30/enable",
+		"PUT /projects/1/deploy_keys/30",
+	}
+	if got := f.writes(); !reflect.DeepEqual(got, wantWrites) {
+		t.Errorf("writes = %v, want %v", got, wantWrites)
+	}
+
+	checks := []string{}
+	for _, c := range cfg.checks {
+		if c.Compliant {
+			checks = append(checks, "pass")
+			continue
+		}
+		checks = append(checks, c.Details)
+	}
+	wantChecks := []string{
+		"pass",
+		"deploy is missing",
+		"#20: can_push: false -> true",
+		"#30 is missing",
+		"#30: can_push: false -> true",
+	}
+	if !reflect.DeepEqual(checks, wantChecks) {
+		t.Errorf("checks = %q, want %q", checks, wantChecks)
+	}
+}
@@ -0,0 +1,261 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// DeployTokenSetting represents a deploy token. ExpiresAt is a date in the
+// YYYY-MM-DD format; tokens without it don't expire.
+type DeployTokenSetting struct {
+	Name      string   `json:"name"`
+	Username  string   `json:"username,omitempty"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt string   `json:"expires_at,omitempty"`
+}
+
+// deployTokenSecret is written to the deploy tokens output for each created token,
+// as GitLab only returns a token's secret when it's created.
+type deployTokenSecret struct {
+	Project  string `json:"project"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+func updateDeployTokensSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch deploy tokens from config file and return if a nil object is returned.
+	cfgSettings, output := cfg.DeployTokensSettings(p.PathWithNamespace)
+	if cfgSettings == nil {
+		return nil
+	}
+
+	// Fetch current project deploy tokens.
+	projectSettings, err := listProjectDeployTokens(client, p.ID)
+	if err != nil {
+		cfg.fetchFailed(p, "Deploy tokens", err)
+		return nil
+	}
+
+	// Loop through deploy tokens mentioned in config file
+	for _, cfgSetting := range cfgSettings {
+		if cfgSetting.Name == "" || len(cfgSetting.Scopes) == 0 {
+			return fmt.Errorf("Deploy tokens must have a name and scopes")
+		}
+
+		var expiresAt *time.Time
+		if cfgSetting.ExpiresAt != "" {
+			t, err := time.Parse("2006-01-02", cfgSetting.ExpiresAt)
+			if err != nil {
+				return fmt.Errorf("Invalid deploy token expires_at: %s", cfgSetting.ExpiresAt)
+			}
+			expiresAt = &t
+		}
+
+		// Look for an active deploy token; if nothing is found we'll create it.
+		var projectSetting *gitlab.DeployToken
+		for _, ps := range projectSettings {
+			if ps.Name == cfgSetting.Name && !ps.Revoked && !ps.Expired {
+				projectSetting = ps
+			}
+		}
+
+		if projectSetting == nil {
+			cfg.check(p, "Deploy tokens", false, fmt.Sprintf("%s is missing", cfgSetting.Name))
+			cfg.printf("Project %s's %s Deploy Token needs creating ... ", p.PathWithNamespace, cfgSetting.Name)
+
+			if cfg.DryRun {
+				cfg.printf("skipping because this is a dry run\n")
+				continue
+			}
+
+			// Only create the token when it's secret can be stored, as it can't be
+			// fetched later.
+			if err := checkDeployTokensOutput(cfg, output); err != nil {
+				cfg.updated(p, "Deploy tokens", err)
+				return err
+			}
+
+			opts := &gitlab.CreateProjectDeployTokenOptions{
+				Name:      &cfgSetting.Name,
+				ExpiresAt: expiresAt,
+				Scopes:    &cfgSetting.Scopes,
+			}
+			if cfgSetting.Username != "" {
+				opts.Username = &cfgSetting.Username
+			}
+			audit, err := cfg.snapshot(p, "deploy_token", cfgSetting.Name, false, &gitlab.DeployToken{Name: cfgSetting.Name})
+			if err != nil {
+				return err
+			}
+
+			cfg.printf("Updating project ... ")
+
+			token, _, err := client.DeployTokens.CreateProjectDeployToken(p.ID, opts, audit)
+			if err != nil {
+				cfg.printf("Failed to create deploy token: %s\n", err)
+				cfg.updated(p, "Deploy tokens", err)
+				continue
+			}
+
+			secret := &deployTokenSecret{
+				Project:  p.PathWithNamespace,
+				Name:     token.Name,
+				Username: token.Username,
+				Token:    token.Token,
+			}
+			if err := writeDeployTokenSecret(output, secret); err != nil {
+				cfg.updated(p, "Deploy tokens", err)
+				return err
+			}
+			cfg.updated(p, "Deploy tokens", nil)
+			cfg.printf("Success!\n")
+
+			continue
+		}
+
+		// Deploy tokens can't be edited, so any difference is only reported.
+		if !sameScopes(projectSetting.Scopes, cfgSetting.Scopes) || !sameDate(projectSetting.ExpiresAt, expiresAt) {
+			cfg.check(p, "Deploy tokens", false, fmt.Sprintf("%s doesn't match the config", cfgSetting.Name))
+			cfg.printf("Project %s's %s Deploy Token doesn't match the config, revoke it to have it recreated\n", p.PathWithNamespace, cfgSetting.Name)
+			continue
+		}
+
+		cfg.check(p, "Deploy tokens", true, "")
+		cfg.printf("Project %s's %s Deploy Token doesn't need updating\n", p.PathWithNamespace, cfgSetting.Name)
+	}
+
+	return nil
+}
+
+// checkDeployTokensOutput returns an error if created tokens can't be stored in
+// the deploy tokens output. Tokens can't be written to stdout by the daemon or
+// webhook server, as nobody is there to read them and they'd end up in the logs.
+func checkDeployTokensOutput(cfg *Config, output string) error {
+	if output == "" {
+		return fmt.Errorf("Deploy tokens require deploytokensoutput to be set so created tokens can be stored")
+	}
+	if output == "-" && cfg.unattended {
+		return fmt.Errorf("Deploy tokens can't be written to stdout by the daemon or serve commands; set deploytokensoutput to a file")
+	}
+
+	return nil
+}
+
+// writeDeployTokenSecret appends a created token's secret to the deploy tokens
+// output as a JSON line, or writes it to stdout when the output is "-". Stdout is
+// written to directly, so the token isn't mixed in with the progress output.
+func writeDeployTokenSecret(output string, secret *deployTokenSecret) error {
+	data, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+
+	if output == "-" {
+		fmt.Fprintf(os.Stdout, "%s\n", data)
+		return nil
+	}
+
+	f, err := os.OpenFile(output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to write deploy token to %s: %s", output, err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "%s\n", data); err != nil {
+		return fmt.Errorf("Failed to write deploy token to %s: %s", output, err)
+	}
+
+	return nil
+}
+
+// restoreDeployToken revokes a deploy token created during a run. As there was no
+// active token with the same name before, any active token with it is revoked.
+func restoreDeployToken(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.DeployToken{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	projectSettings, err := listProjectDeployTokens(client, s.ProjectID)
+	if err != nil {
+		return err
+	}
+	for _, ps := range projectSettings {
+		if ps.Name == before.Name && !ps.Revoked && !ps.Expired {
+			if _, err := client.DeployTokens.DeleteProjectDeployToken(s.ProjectID, ps.ID, audit); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sameScopes returns true if two lists hold the same scopes, in any order.
+func sameScopes(a, b []string) bool {
+	x := append([]string{}, a...)
+	y := append([]string{}, b...)
+	sort.Strings(x)
+	sort.Strings(y)
+
+	return compareObjects(x, y)
+}
+
+// sameDate returns true if two optional times fall on the same day.
+func sameDate(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return a.UTC().Format("2006-01-02") == b.UTC().Format("2006-01-02")
+}
+
+// listProjectDeployTokens returns a slice containing all deploy tokens of a
+// project.
+func listProjectDeployTokens(client *gitlab.Client, pid int) ([]*gitlab.DeployToken, error) {
+	tokens := []*gitlab.DeployToken{}
+
+	opt := &gitlab.ListProjectDeployTokensOptions{
+		PerPage: 100,
+		Page:    1,
+	}
+
+	for {
+		ts, resp, err := client.DeployTokens.ListProjectDeployTokens(pid, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, ts...)
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		opt.Page = resp.NextPage
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,120 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdateDeployTokensSettings(t *testing.T) {
+	existing := `[{"id": 1, "name": "registry-pull", "username": "gitlab+deploy-token-1", "scopes": ["read_registry"]}]`
+	created := `{"id": 2, "name": "ci", "username": "gitlab+deploy-token-2", "token": "s3cr3t", "scopes": ["read_repository"]}`
+
+	tests := []struct {
+		name       string
+		tokens     string
+		output     string
+		dryRun     bool
+		unattended bool
+		wantErr    bool
+		wantWrites []string
+		wantToken  bool
+	}{
+		{
+			name:       "token exists without an output",
+			tokens:     existing,
+			wantWrites: []string{},
+		},
+		{
+			name:       "dry run without an output",
+			tokens:     `[]`,
+			dryRun:     true,
+			wantWrites: []string{},
+		},
+		{
+			name:       "create without an output",
+			tokens:     `[]`,
+			wantErr:    true,
+			wantWrites: []string{},
+		},
+		{
+			name:       "create to stdout when unattended",
+			tokens:     `[]`,
+			output:     "-",
+			unattended: true,
+			wantErr:    true,
+			wantWrites: []string{},
+		},
+		{
+			name:       "create to a file",
+			tokens:     `[]`,
+			output:     "tokens.jsonl",
+			wantWrites: []string{"POST /projects/1/deploy_tokens"},
+			wantToken:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client, cfg := newFakeGitLab(t, map[string]string{
+				"GET /projects/1/deploy_tokens":  tt.tokens,
+				"POST /projects/1/deploy_tokens": created,
+			})
+			cfg.DryRun = tt.dryRun
+			cfg.unattended = tt.unattended
+
+			name := "registry-pull"
+			scopes := []string{"read_registry"}
+			if tt.tokens == `[]` {
+				name = "ci"
+				scopes = []string{"read_repository"}
+			}
+			cfg.Groups[0].Repository.DeployTokens = []*DeployTokenSetting{{Name: name, Scopes: scopes}}
+			if tt.output != "" && tt.output != "-" {
+				tt.output = filepath.Join(t.TempDir(), tt.output)
+			}
+			cfg.Groups[0].Repository.DeployTokensOutput = tt.output
+
+			err := updateDeployTokensSettings(client, testProject, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("updateDeployTokensSettings() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if got := f.writes(); !reflect.DeepEqual(got, tt.wantWrites) {
+				t.Errorf("writes = %v, want %v", got, tt.wantWrites)
+			}
+
+			if !tt.wantToken {
+				return
+			}
+			data, err := ioutil.ReadFile(tt.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), `"token":"s3cr3t"`) {
+				t.Errorf("output = %s, want it to hold the created token", data)
+			}
+			if check := onlyCheck(t, cfg, "Deploy tokens"); !check.Updated {
+				t.Errorf("check wasn't marked as updated")
+			}
+		})
+	}
+}
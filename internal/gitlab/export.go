@@ -0,0 +1,266 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// exportedSetting describes a setting that can be exported, and how to read it
+// from and write it to a Settings object.
+type exportedSetting struct {
+	name string
+	get  func(*Settings) interface{}
+	set  func(dst, src *Settings)
+}
+
+// exportedSettings lists the settings read from projects by ExportGroup.
+var exportedSettings = []exportedSetting{
+	{
+		name: "Merge Request Approval settings",
+		get:  func(s *Settings) interface{} { return s.General.MergeRequestApprovals },
+		set:  func(dst, src *Settings) { dst.General.MergeRequestApprovals = src.General.MergeRequestApprovals },
+	},
+	{
+		name: "Protected Branches",
+		get:  func(s *Settings) interface{} { return s.Repository.ProtectedBranches },
+		set:  func(dst, src *Settings) { dst.Repository.ProtectedBranches = src.Repository.ProtectedBranches },
+	},
+	{
+		name: "Slack settings",
+		get:  func(s *Settings) interface{} { return s.Integrations.Slack },
+		set:  func(dst, src *Settings) { dst.Integrations.Slack = src.Integrations.Slack },
+	},
+}
+
+// ExportGroup reads the Merge Request Approval, Protected Branches and Slack
+// settings of the projects in a group and returns them as the group's settings.
+// The value used by most projects is set on the group, while projects using a
+// different value get it as a project override. Progress is printed to stderr so
+// the exported config can be written to stdout.
+func ExportGroup(cfg *Config, name string) (*Settings, error) {
+	client, err := newClient(*cfg.APIToken, *cfg.APIURL)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := listGroups(client)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(os.Stderr, "Looking up group with name \"%s\" ... ", name)
+	id, err := groups.getID(name)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "matched group to ID %d\n", id)
+
+	projects, err := listGroupProjects(client, id)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := []*Settings{}
+	for _, p := range projects {
+		fmt.Fprintf(os.Stderr, "Reading project %s's settings\n", p.PathWithNamespace)
+
+		s, err := exportProject(client, p)
+		if err != nil {
+			return nil, err
+		}
+		s.Name = strings.TrimPrefix(p.PathWithNamespace, name+"/")
+		exported = append(exported, s)
+	}
+
+	return hoistSettings(name, exported), nil
+}
+
+// hoistSettings returns group settings holding the most used value of each
+// setting, with project overrides for projects using another value.
+func hoistSettings(name string, projects []*Settings) *Settings {
+	group := &Settings{Name: name}
+	overrides := map[string]*Settings{}
+
+	for _, es := range exportedSettings {
+		// Find the value used by most projects; on a tie the first one found wins.
+		var common *Settings
+		count := 0
+		for _, a := range projects {
+			n := 0
+			for _, b := range projects {
+				if compareObjects(es.get(a), es.get(b)) {
+					n++
+				}
+			}
+			if n > count {
+				common, count = a, n
+			}
+		}
+		if common == nil {
+			continue
+		}
+		es.set(group, common)
+
+		for _, p := range projects {
+			if compareObjects(es.get(p), es.get(common)) {
+				continue
+			}
+
+			// An empty value can't override a group's value, so these projects will
+			// inherit the group's value when the config is applied.
+			if compareObjects(es.get(p), es.get(&Settings{})) {
+				fmt.Fprintf(os.Stderr, "Project %s has no %s and will inherit the group's when this config is applied\n", p.Name, es.name)
+				continue
+			}
+
+			if overrides[p.Name] == nil {
+				overrides[p.Name] = &Settings{Name: p.Name}
+				group.Projects = append(group.Projects, overrides[p.Name])
+			}
+			es.set(overrides[p.Name], p)
+		}
+	}
+
+	return group
+}
+
+// exportProject returns the exported settings of a project.
+func exportProject(client *gitlab.Client, p *gitlab.Project) (*Settings, error) {
+	s := &Settings{}
+
+	approvals, _, err := client.Projects.GetApprovalConfiguration(p.ID)
+	if err == nil {
+		s.General.MergeRequestApprovals = MergeRequestApprovalSettings{
+			Mode: "exact",
+			ProjectApprovals: gitlab.ProjectApprovals{
+				ApprovalsBeforeMerge:                      approvals.ApprovalsBeforeMerge,
+				ResetApprovalsOnPush:                      approvals.ResetApprovalsOnPush,
+				DisableOverridingApproversPerMergeRequest: approvals.DisableOverridingApproversPerMergeRequest,
+				MergeRequestsAuthorApproval:               approvals.MergeRequestsAuthorApproval,
+				MergeRequestsDisableCommittersApproval:    approvals.MergeRequestsDisableCommittersApproval,
+				RequirePasswordToApprove:                  approvals.RequirePasswordToApprove,
+				SelectiveCodeOwnerRemovals:                approvals.SelectiveCodeOwnerRemovals,
+			},
+		}
+	}
+
+	branches, _, err := client.ProtectedBranches.ListProtectedBranches(p.ID, &gitlab.ListProtectedBranchesOptions{ListOptions: gitlab.ListOptions{PerPage: 100}})
+	if err == nil {
+		for _, b := range branches {
+			setting := &ProtectedBranchSetting{
+				Name:           b.Name,
+				AllowedToMerge: branchAccessName(b.MergeAccessLevels),
+				AllowedToPush:  branchAccessName(b.PushAccessLevels),
+			}
+			if setting.AllowedToMerge == "" || setting.AllowedToPush == "" {
+				fmt.Fprintf(os.Stderr, "Project %s's %s Branch Protection uses access that can't be exported, skipping\n", p.PathWithNamespace, b.Name)
+				continue
+			}
+			s.Repository.ProtectedBranches = append(s.Repository.ProtectedBranches, setting)
+		}
+	}
+
+	slack, _, err := client.Services.GetSlackService(p.ID)
+	if err == nil && slack.Active {
+		s.Integrations.Slack = exportSlack(slack)
+	}
+
+	return s, nil
+}
+
+// exportSlack converts a project's Slack settings to the config's Slack
+// settings. The webhook is write-only and can't be exported.
+func exportSlack(slack *gitlab.SlackService) SlackSettings {
+	s := SlackSettings{
+		Mode:   "exact",
+		Active: slack.Active,
+		Events: []string{},
+	}
+
+	events := []struct {
+		name    string
+		enabled bool
+	}{
+		{"alert", slack.AlertEvents},
+		{"confidential_issues", slack.ConfidentialIssuesEvents},
+		{"confidential_note", slack.ConfidentialNoteEvents},
+		{"deployment", slack.DeploymentEvents},
+		{"issues", slack.IssuesEvents},
+		{"merge_request", slack.MergeRequestsEvents},
+		{"note", slack.NoteEvents},
+		{"pipeline", slack.PipelineEvents},
+		{"push", slack.PushEvents},
+		{"tags", slack.TagPushEvents},
+		{"vulnerability", slack.VulnerabilityEvents},
+		{"wiki_page", slack.WikiPageEvents},
+	}
+	for _, e := range events {
+		if e.enabled {
+			s.Events = append(s.Events, e.name)
+		}
+	}
+
+	if props := slack.Properties; props != nil {
+		notifyOnlyBrokenPipelines := bool(props.NotifyOnlyBrokenPipelines)
+		notifyOnlyDefaultBranch := bool(props.NotifyOnlyDefaultBranch)
+		s.Properties = SlackProperties{
+			Username:                  props.Username,
+			Channel:                   props.Channel,
+			NotifyOnlyBrokenPipelines: &notifyOnlyBrokenPipelines,
+			NotifyOnlyDefaultBranch:   &notifyOnlyDefaultBranch,
+			BranchesToBeNotified:      props.BranchesToBeNotified,
+			AlertChannel:              props.AlertChannel,
+			ConfidentialIssueChannel:  props.ConfidentialIssueChannel,
+			ConfidentialNoteChannel:   props.ConfidentialNoteChannel,
+			DeploymentChannel:         props.DeploymentChannel,
+			IssueChannel:              props.IssueChannel,
+			MergeRequestChannel:       props.MergeRequestChannel,
+			NoteChannel:               props.NoteChannel,
+			TagPushChannel:            props.TagPushChannel,
+			PipelineChannel:           props.PipelineChannel,
+			PushChannel:               props.PushChannel,
+			VulnerabilityChannel:      props.VulnerabilityChannel,
+			WikiPageChannel:           props.WikiPageChannel,
+		}
+	}
+
+	return s
+}
+
+// branchAccessName converts a protected branch's access levels to the name used
+// in the config, or an empty string if they can't be expressed in the config.
+func branchAccessName(ds []*gitlab.BranchAccessDescription) string {
+	if len(ds) != 1 || ds[0].UserID != 0 || ds[0].GroupID != 0 || ds[0].DeployKeyID != 0 {
+		return ""
+	}
+
+	switch ds[0].AccessLevel {
+	case gitlab.NoPermissions:
+		return "no one"
+	case gitlab.DeveloperPermissions:
+		return "developers"
+	case gitlab.MaintainerPermissions:
+		return "maintainers"
+	}
+
+	return ""
+}
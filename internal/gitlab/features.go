@@ -0,0 +1,171 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// FeaturesSettings represents a project's visibility and the access levels of
+// it's features.
+type FeaturesSettings struct {
+	Visibility                       string `json:"visibility,omitempty"`
+	AnalyticsAccessLevel             string `json:"analytics_access_level,omitempty"`
+	BuildsAccessLevel                string `json:"builds_access_level,omitempty"`
+	ContainerRegistryAccessLevel     string `json:"container_registry_access_level,omitempty"`
+	EnvironmentsAccessLevel          string `json:"environments_access_level,omitempty"`
+	FeatureFlagsAccessLevel          string `json:"feature_flags_access_level,omitempty"`
+	ForkingAccessLevel               string `json:"forking_access_level,omitempty"`
+	InfrastructureAccessLevel        string `json:"infrastructure_access_level,omitempty"`
+	IssuesAccessLevel                string `json:"issues_access_level,omitempty"`
+	MergeRequestsAccessLevel         string `json:"merge_requests_access_level,omitempty"`
+	MonitorAccessLevel               string `json:"monitor_access_level,omitempty"`
+	OperationsAccessLevel            string `json:"operations_access_level,omitempty"`
+	PagesAccessLevel                 string `json:"pages_access_level,omitempty"`
+	ReleasesAccessLevel              string `json:"releases_access_level,omitempty"`
+	RepositoryAccessLevel            string `json:"repository_access_level,omitempty"`
+	RequirementsAccessLevel          string `json:"requirements_access_level,omitempty"`
+	SecurityAndComplianceAccessLevel string `json:"security_and_compliance_access_level,omitempty"`
+	SnippetsAccessLevel              string `json:"snippets_access_level,omitempty"`
+	WikiAccessLevel                  string `json:"wiki_access_level,omitempty"`
+	PackagesEnabled                  *bool  `json:"packages_enabled,omitempty"`
+}
+
+func updateFeaturesSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch feature settings from config file and return if a nil object is returned.
+	cfgSettings := cfg.FeaturesSettings(p.PathWithNamespace)
+	if cfgSettings == nil || compareObjects(cfgSettings, &FeaturesSettings{}) {
+		return nil
+	}
+	if err := cfgSettings.validate(); err != nil {
+		return err
+	}
+
+	// Fetch current project settings. These are compared as returned by the API, so
+	// settings go-gitlab's Project doesn't hold are compared too.
+	projectSettings, err := getProjectAttributes(client, p.ID)
+	if err != nil {
+		cfg.fetchFailed(p, "Visibility and features", err)
+		return nil
+	}
+
+	// Only compare the settings defined in our config, thus we do not override any
+	// settings that we don't define.
+	newSettings := map[string]interface{}{}
+	settingsData, _ := json.Marshal(cfgSettings)
+	if err := json.Unmarshal(settingsData, &newSettings); err != nil {
+		return err
+	}
+
+	changes := []string{}
+	before := map[string]interface{}{}
+	for _, k := range sortedKeys(newSettings) {
+		before[k] = projectSettings[k]
+		if fmt.Sprint(projectSettings[k]) != fmt.Sprint(newSettings[k]) {
+			changes = append(changes, diffValue(k, projectSettings[k], newSettings[k]))
+		}
+	}
+
+	// Return if our proposed config matches the actual config
+	if len(changes) == 0 {
+		cfg.check(p, "Visibility and features", true, "")
+		cfg.printf("Project %s's Visibility and feature settings don't need updating\n", p.PathWithNamespace)
+		return nil
+	}
+
+	cfg.check(p, "Visibility and features", false, strings.Join(changes, ", "))
+
+	cfg.printf("Project %s's Visibility and feature settings need updating ... ", p.PathWithNamespace)
+
+	if cfg.DryRun {
+		cfg.printf("skipping because this is a dry run\n")
+		return nil
+	}
+
+	opts := &gitlab.EditProjectOptions{}
+	if err := json.Unmarshal(settingsData, &opts); err != nil {
+		return err
+	}
+	audit, err := cfg.snapshot(p, "project", "visibility and features", true, before)
+	if err != nil {
+		return err
+	}
+
+	cfg.printf("Updating project ... ")
+
+	_, _, err = client.Projects.EditProject(p.ID, opts, audit)
+	if err != nil {
+		cfg.updated(p, "Visibility and features", err)
+		return err
+	}
+	cfg.updated(p, "Visibility and features", nil)
+	cfg.printf("Success!\n")
+
+	return nil
+}
+
+// getProjectAttributes fetches a project's attributes as returned by the API.
+func getProjectAttributes(client *gitlab.Client, pid int) (map[string]interface{}, error) {
+	req, err := client.NewRequest(http.MethodGet, fmt.Sprintf("projects/%d", pid), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]interface{}{}
+	if _, err := client.Do(req, &attrs); err != nil {
+		return nil, err
+	}
+
+	return attrs, nil
+}
+
+// validate checks the configured visibility and access levels are supported by
+// GitLab.
+func (f *FeaturesSettings) validate() error {
+	settings := map[string]interface{}{}
+	settingsData, _ := json.Marshal(f)
+	if err := json.Unmarshal(settingsData, &settings); err != nil {
+		return err
+	}
+
+	for _, k := range sortedKeys(settings) {
+		v, ok := settings[k].(string)
+		if !ok {
+			continue
+		}
+
+		// Only Pages can be made public, as it's the only feature that can be
+		// accessed without access to the project.
+		valid := []string{"disabled", "private", "enabled"}
+		switch k {
+		case "visibility":
+			valid = []string{"private", "internal", "public"}
+		case "pages_access_level":
+			valid = append(valid, "public")
+		}
+		if !contains(valid, v) {
+			return fmt.Errorf("Invalid %s: %s", k, v)
+		}
+	}
+
+	return nil
+}
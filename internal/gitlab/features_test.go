@@ -0,0 +1,105 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdateFeaturesSettings(t *testing.T) {
+	// The API returns settings go-gitlab's Project doesn't hold, which are compared
+	// as they are.
+	current := `{"id": 1, "visibility": "private", "wiki_access_level": "enabled", "pages_access_level": "public", "packages_enabled": true}`
+	packagesEnabled := true
+
+	tests := []struct {
+		name        string
+		settings    FeaturesSettings
+		wantErr     bool
+		wantWrites  []string
+		wantDetails string
+		wantBody    []string
+	}{
+		{
+			name:       "matches",
+			settings:   FeaturesSettings{Visibility: "private", PagesAccessLevel: "public", PackagesEnabled: &packagesEnabled},
+			wantWrites: []string{},
+		},
+		{
+			name:        "differs",
+			settings:    FeaturesSettings{Visibility: "internal", WikiAccessLevel: "disabled"},
+			wantWrites:  []string{"PUT /projects/1"},
+			wantDetails: "visibility: private -> internal, wiki_access_level: enabled -> disabled",
+			wantBody:    []string{`"visibility":"internal"`, `"wiki_access_level":"disabled"`},
+		},
+		{
+			name:     "only pages can be public",
+			settings: FeaturesSettings{IssuesAccessLevel: "public"},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid visibility",
+			settings: FeaturesSettings{Visibility: "secret"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client, cfg := newFakeGitLab(t, map[string]string{
+				"GET /projects/1": current,
+				"PUT /projects/1": current,
+			})
+			cfg.Groups[0].General.Features = tt.settings
+
+			err := updateFeaturesSettings(client, testProject, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("updateFeaturesSettings() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if len(f.requests) > 0 {
+					t.Errorf("requests = %v, want none for an invalid config", f.requests)
+				}
+				return
+			}
+
+			if got := f.writes(); !reflect.DeepEqual(got, tt.wantWrites) {
+				t.Fatalf("writes = %v, want %v", got, tt.wantWrites)
+			}
+
+			check := onlyCheck(t, cfg, "Visibility and features")
+			if check.Compliant != (tt.wantDetails == "") || check.Details != tt.wantDetails {
+				t.Errorf("check = %+v, want details %q", check, tt.wantDetails)
+			}
+
+			if len(tt.wantWrites) == 0 {
+				return
+			}
+			body := f.request(t, "PUT /projects/1").Body
+			for _, want := range tt.wantBody {
+				if !strings.Contains(body, want) {
+					t.Errorf("request body %s doesn't contain %s", body, want)
+				}
+			}
+			if strings.Contains(body, "pages_access_level") {
+				t.Errorf("request body %s changes a setting that isn't configured", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// repositoryFile represents a file that should exist with the given content on a
+// project's default branch.
+type repositoryFile struct {
+	Path    string
+	Content string
+}
+
+// filesSnapshot represents the branch files are committed to, and it's merge
+// request, before the files were updated.
+type filesSnapshot struct {
+	Branch       string `json:"branch"`
+	Commit       string `json:"commit,omitempty"`
+	MergeRequest int    `json:"merge_request,omitempty"`
+}
+
+// updateFilesViaMergeRequest compares files with a project's default branch and,
+// when any differ, commits them to a dedicated branch and opens a merge request
+// for it. The branch is recreated from the default branch on each update, so an
+// open merge request always holds a single commit with the wanted changes. A
+// branch holding commits made by anyone else is left alone, as recreating it
+// would throw those commits away.
+func updateFilesViaMergeRequest(client *gitlab.Client, p *gitlab.Project, cfg *Config, name, branch string, files []*repositoryFile) error {
+	if p.DefaultBranch == "" {
+		cfg.printf("Project %s's %s can't be updated as the repository is empty, skipping\n", p.PathWithNamespace, name)
+		return nil
+	}
+
+	// Work out which files need creating or updating on the default branch.
+	actions := []*gitlab.CommitActionOptions{}
+	offending := []string{}
+	for _, f := range files {
+		content, exists, err := getRepositoryFile(client, p.ID, f.Path, p.DefaultBranch)
+		if err != nil {
+			cfg.fetchFailed(p, name, err)
+			return nil
+		}
+		if exists && content == f.Content {
+			continue
+		}
+
+		action := gitlab.FileUpdate
+		offending = append(offending, fmt.Sprintf("%s differs", f.Path))
+		if !exists {
+			action = gitlab.FileCreate
+			offending[len(offending)-1] = fmt.Sprintf("%s is missing", f.Path)
+		}
+		actions = append(actions, &gitlab.CommitActionOptions{
+			Action:   &action,
+			FilePath: &f.Path,
+			Content:  &f.Content,
+		})
+	}
+
+	if len(actions) == 0 {
+		cfg.check(p, name, true, "")
+		cfg.printf("Project %s's %s don't need updating\n", p.PathWithNamespace, name)
+		return nil
+	}
+
+	cfg.check(p, name, false, strings.Join(offending, ", "))
+
+	mr, err := openMergeRequest(client, p.ID, branch)
+	if err != nil {
+		cfg.fetchFailed(p, name, err)
+		return nil
+	}
+
+	// Leave an open merge request alone if it already holds the wanted changes.
+	if mr != nil {
+		pending := true
+		for _, f := range files {
+			content, exists, err := getRepositoryFile(client, p.ID, f.Path, branch)
+			if err != nil || !exists || content != f.Content {
+				pending = false
+				break
+			}
+		}
+		if pending {
+			cfg.printf("Project %s's %s are waiting on merge request !%d\n", p.PathWithNamespace, name, mr.IID)
+			return nil
+		}
+	}
+
+	cfg.printf("Project %s's %s need updating ... ", p.PathWithNamespace, name)
+
+	if cfg.DryRun {
+		cfg.printf("skipping because this is a dry run\n")
+		return nil
+	}
+
+	before := &filesSnapshot{Branch: branch}
+	if mr != nil {
+		before.MergeRequest = mr.IID
+	}
+	b, resp, err := client.Branches.GetBranch(p.ID, branch)
+	switch {
+	case err == nil:
+		before.Commit = b.Commit.ID
+	case resp == nil || resp.StatusCode != http.StatusNotFound:
+		cfg.printf("Failed to fetch branch: %s\n", err)
+		cfg.updated(p, name, err)
+		return nil
+	}
+
+	title := fmt.Sprintf("Update %s", name)
+	if before.Commit != "" {
+		diverged, err := branchDiverged(client, p.ID, p.DefaultBranch, branch, title)
+		if err != nil {
+			cfg.printf("Failed to compare branch: %s\n", err)
+			cfg.updated(p, name, err)
+			return nil
+		}
+		if diverged {
+			err := fmt.Errorf("branch %s holds commits that weren't made by repo-settings", branch)
+			cfg.printf("Failed to update branch: %s\n", err)
+			cfg.updated(p, name, err)
+			return nil
+		}
+	}
+
+	audit, err := cfg.snapshot(p, "files", name, before.Commit != "", before)
+	if err != nil {
+		return err
+	}
+
+	force := true
+	commitOpts := &gitlab.CreateCommitOptions{
+		Branch:        &branch,
+		StartBranch:   &p.DefaultBranch,
+		CommitMessage: &title,
+		Actions:       actions,
+		Force:         &force,
+	}
+
+	cfg.printf("Updating project ... ")
+
+	if _, _, err := client.Commits.CreateCommit(p.ID, commitOpts, audit); err != nil {
+		cfg.printf("Failed to commit files: %s\n", err)
+		cfg.updated(p, name, err)
+		return nil
+	}
+
+	if mr == nil {
+		description := fmt.Sprintf("This merge request was opened by repo-settings to update the project's %s.", name)
+		removeSourceBranch := true
+		mrOpts := &gitlab.CreateMergeRequestOptions{
+			Title:              &title,
+			Description:        &description,
+			SourceBranch:       &branch,
+			TargetBranch:       &p.DefaultBranch,
+			RemoveSourceBranch: &removeSourceBranch,
+		}
+
+		if _, _, err := client.MergeRequests.CreateMergeRequest(p.ID, mrOpts, audit); err != nil {
+			cfg.printf("Failed to open merge request: %s\n", err)
+			cfg.updated(p, name, err)
+			return nil
+		}
+	}
+	cfg.updated(p, name, nil)
+	cfg.printf("Success!\n")
+
+	return nil
+}
+
+// branchDiverged returns true if a branch holds commits that aren't on the
+// default branch and weren't made by repo-settings, which are recognised by
+// their title.
+func branchDiverged(client *gitlab.Client, pid int, defaultBranch, branch, title string) (bool, error) {
+	compare, _, err := client.Repositories.Compare(pid, &gitlab.CompareOptions{From: &defaultBranch, To: &branch})
+	if err != nil {
+		return false, err
+	}
+
+	for _, c := range compare.Commits {
+		if c.Title != title {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// restoreFiles resets the branch files were committed to, removing it if it
+// didn't exist, and closes the merge request if it was opened during the run.
+// The default branch is never changed, as files only reach it once the merge
+// request is merged.
+func restoreFiles(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &filesSnapshot{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	if before.MergeRequest == 0 {
+		mr, err := openMergeRequest(client, s.ProjectID, before.Branch)
+		if err != nil {
+			return err
+		}
+		if mr != nil {
+			state := "close"
+			if _, _, err := client.MergeRequests.UpdateMergeRequest(s.ProjectID, mr.IID, &gitlab.UpdateMergeRequestOptions{StateEvent: &state}, audit); err != nil {
+				return err
+			}
+		}
+	}
+
+	resp, err := client.Branches.DeleteBranch(s.ProjectID, before.Branch, audit)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return err
+	}
+	if before.Commit == "" {
+		return nil
+	}
+
+	_, _, err = client.Branches.CreateBranch(s.ProjectID, &gitlab.CreateBranchOptions{Branch: &before.Branch, Ref: &before.Commit}, audit)
+
+	return err
+}
+
+// getRepositoryFile returns the content of a file on a branch and whether the
+// file exists.
+func getRepositoryFile(client *gitlab.Client, pid int, path, ref string) (string, bool, error) {
+	content, resp, err := client.RepositoryFiles.GetRawFile(pid, path, &gitlab.GetRawFileOptions{Ref: &ref})
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	return string(content), true, nil
+}
+
+// openMergeRequest returns the open merge request for a source branch, or nil if
+// there isn't one.
+func openMergeRequest(client *gitlab.Client, pid int, branch string) (*gitlab.MergeRequest, error) {
+	state := "opened"
+	opt := &gitlab.ListProjectMergeRequestsOptions{
+		State:        &state,
+		SourceBranch: &branch,
+	}
+
+	mrs, _, err := client.MergeRequests.ListProjectMergeRequests(pid, opt)
+	if err != nil {
+		return nil, err
+	}
+	if len(mrs) == 0 {
+		return nil, nil
+	}
+
+	return mrs[0], nil
+}
+
+// readLocalFile returns the content of a local file referenced in the config.
+func readLocalFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read %s: %s", path, err)
+	}
+
+	return string(content), nil
+}
@@ -0,0 +1,109 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestUpdateFilesViaMergeRequest(t *testing.T) {
+	branch := "GET /projects/1/repository/branches/repo-settings/files"
+
+	tests := []struct {
+		name       string
+		responses  map[string]string
+		statuses   map[string]int
+		wantWrites []string
+		wantError  string
+	}{
+		{
+			name:       "new branch",
+			responses:  map[string]string{"GET /projects/1/merge_requests": `[]`},
+			wantWrites: []string{"POST /projects/1/repository/commits", "POST /projects/1/merge_requests"},
+		},
+		{
+			name: "branch with an open merge request",
+			responses: map[string]string{
+				"GET /projects/1/merge_requests":     `[{"iid": 3, "source_branch": "repo-settings/files"}]`,
+				branch:                               `{"name": "repo-settings/files", "commit": {"id": "abc"}}`,
+				"GET /projects/1/repository/compare": `{"commits": [{"title": "Update files"}]}`,
+			},
+			wantWrites: []string{"POST /projects/1/repository/commits"},
+		},
+		{
+			name: "branch with someone else's commits",
+			responses: map[string]string{
+				"GET /projects/1/merge_requests":     `[{"iid": 3, "source_branch": "repo-settings/files"}]`,
+				branch:                               `{"name": "repo-settings/files", "commit": {"id": "abc"}}`,
+				"GET /projects/1/repository/compare": `{"commits": [{"title": "Update files"}, {"title": "Fix typo"}]}`,
+			},
+			wantWrites: []string{},
+			wantError:  "branch repo-settings/files holds commits that weren't made by repo-settings",
+		},
+		{
+			name: "branch can't be fetched",
+			responses: map[string]string{
+				"GET /projects/1/merge_requests": `[]`,
+				branch:                           `{"message": "403 Forbidden"}`,
+			},
+			statuses:   map[string]int{branch: http.StatusForbidden},
+			wantWrites: []string{},
+			wantError:  "GET ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.responses["GET /projects/1/repository/files/README.md/raw"] = "old"
+			tt.responses["POST /projects/1/repository/commits"] = `{"id": "def"}`
+			tt.responses["POST /projects/1/merge_requests"] = `{"iid": 4}`
+			f, client, cfg := newFakeGitLab(t, tt.responses)
+			for route, status := range tt.statuses {
+				f.statuses[route] = status
+			}
+			p := &gitlab.Project{ID: 1, PathWithNamespace: "acme/api", DefaultBranch: "main"}
+			files := []*repositoryFile{{Path: "README.md", Content: "new"}}
+
+			if err := updateFilesViaMergeRequest(client, p, cfg, "files", "repo-settings/files", files); err != nil {
+				t.Fatalf("updateFilesViaMergeRequest() error = %v", err)
+			}
+
+			if got := f.writes(); !reflect.DeepEqual(got, tt.wantWrites) {
+				t.Fatalf("writes = %v, want %v", got, tt.wantWrites)
+			}
+
+			check := onlyCheck(t, cfg, "files")
+			if check.Compliant || check.Details != "README.md differs" {
+				t.Errorf("check = %+v, want README.md to differ", check)
+			}
+			if tt.wantError == "" {
+				if !check.Updated || check.Error != "" {
+					t.Errorf("check = %+v, want it to be updated", check)
+				}
+				return
+			}
+			if check.Updated || !strings.HasPrefix(check.Error, tt.wantError) {
+				t.Errorf("check = %+v, want error %q", check, tt.wantError)
+			}
+		})
+	}
+}
@@ -18,10 +18,11 @@ package gitlab
 
 import (
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"reflect"
 	"strings"
-	"log"
 
 	"github.com/xanzy/go-gitlab"
 )
@@ -33,27 +34,81 @@ type Config struct {
 	Defaults *Settings   `json:"defaults,omitempty"`
 	Groups   []*Settings `json:"groups,omitempty"`
 	DryRun   bool
+
+	// RunID identifies a run in the snapshots of the settings it changed, and
+	// SnapshotDir is where these snapshots are written.
+	RunID       string
+	SnapshotDir string
+
+	// Output is where progress is written to, stdout if it's nil.
+	Output io.Writer
+
+	// AuditLog is where every change made is logged; either a file path or
+	// "syslog". Nothing is logged if it's empty.
+	AuditLog string
+
+	// auditLog is the opened audit log and operator the user changes are made as.
+	auditLog io.Writer
+	operator string
+
+	// report is set while evaluating projects for a report, where secrets aren't
+	// resolved so a report can be made without access to them.
+	report bool
+
+	// unattended is set for runs made by the daemon and webhook server, whose
+	// output nobody reads.
+	unattended bool
+
+	// checks holds the result of each check made while processing projects.
+	checks []*Check
 }
 
 // Settings represents a group's settings.
 type Settings struct {
 	Name    string `json:"name,omitempty"`
 	General struct {
-		MergeRequestApprovals gitlab.ProjectApprovals `json:"merge_request_approvals,omitempty"`
+		Badges                []*BadgeSetting              `json:"badges,omitempty"`
+		Features              FeaturesSettings             `json:"features,omitempty"`
+		MergeRequestApprovals MergeRequestApprovalSettings `json:"merge_request_approvals,omitempty"`
 	} `json:"general,omitempty"`
+	Issues struct {
+		Labels               []*LabelSetting `json:"labels,omitempty"`
+		RemoveUnlistedLabels bool            `json:"remove_unlisted_labels,omitempty"`
+	} `json:"issues,omitempty"`
+	Packages struct {
+		ContainerExpirationPolicy ContainerExpirationPolicySettings `json:"container_expiration_policy,omitempty"`
+	} `json:"packages,omitempty"`
 	Repository struct {
-		ProtectedBranches []*ProtectedBranchSetting `json:"protected_branches,omitempty"`
+		DefaultBranch      string                    `json:"default_branch,omitempty"`
+		DeployKeys         []*DeployKeySetting       `json:"deploy_keys,omitempty"`
+		DeployTokens       []*DeployTokenSetting     `json:"deploy_tokens,omitempty"`
+		DeployTokensOutput string                    `json:"deploy_tokens_output,omitempty"`
+		Files              []*FileSetting            `json:"files,omitempty"`
+		FilesBranch        string                    `json:"files_branch,omitempty"`
+		Mirrors            []*MirrorSetting          `json:"mirrors,omitempty"`
+		ProtectedBranches  []*ProtectedBranchSetting `json:"protected_branches,omitempty"`
 	} `json:"repository,omitempty"`
+	CI struct {
+		General   CIGeneralSettings  `json:"general,omitempty"`
+		Schedules []*ScheduleSetting `json:"schedules,omitempty"`
+		Variables []*VariableSetting `json:"variables,omitempty"`
+	} `json:"ci,omitempty"`
 	Integrations struct {
-		Slack SlackSettings `json:"slack,omitempty"`
+		Datadog        IntegrationSettings `json:"datadog,omitempty"`
+		Discord        ChatSettings        `json:"discord,omitempty"`
+		EmailsOnPush   IntegrationSettings `json:"emails_on_push,omitempty"`
+		GoogleChat     ChatSettings        `json:"google_chat,omitempty"`
+		Jira           JiraSettings        `json:"jira,omitempty"`
+		Mattermost     ChatSettings        `json:"mattermost,omitempty"`
+		MicrosoftTeams ChatSettings        `json:"microsoft_teams,omitempty"`
+		PipelinesEmail IntegrationSettings `json:"pipelines_email,omitempty"`
+		Prometheus     IntegrationSettings `json:"prometheus,omitempty"`
+		Slack          SlackSettings       `json:"slack,omitempty"`
 	} `json:"integrations,omitempty"`
-}
-
-// SlackSettings represents a project's Slack settings.
-type SlackSettings struct {
-	Active     bool                          `json:"active"`
-	Events     []string                      `json:"events"`
-	Properties gitlab.SlackServiceProperties `json:"properties,omitempty"`
+	Lifecycle LifecycleSettings `json:"lifecycle,omitempty"`
+	Members   MembersSettings   `json:"members,omitempty"`
+	Templates TemplatesSettings `json:"templates,omitempty"`
+	Projects  []*Settings       `json:"projects,omitempty"`
 }
 
 // LoadCreds accepts a token and url string; if these are empty it will attempt
@@ -76,60 +131,226 @@ func (c *Config) LoadCreds(token, url string) error {
 	return nil
 }
 
+// LabelsSettings will return the labels for a project, and whether labels that
+// aren't listed should be removed, by looking up it's namespace in the config.
+func (c *Config) LabelsSettings(ns string) ([]*LabelSetting, bool) {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil, false
+	}
+
+	return g.Issues.Labels, g.Issues.RemoveUnlistedLabels
+}
+
+// LifecycleSettings will return the lifecycle rules for a project by looking up
+// it's namespace in the config.
+func (c *Config) LifecycleSettings(ns string) *LifecycleSettings {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
+
+	return &g.Lifecycle
+}
+
+// MembersSettings will return the members for a project by looking up it's
+// namespace in the config.
+func (c *Config) MembersSettings(ns string) *MembersSettings {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
+
+	return &g.Members
+}
+
 // MergeRequestApprovalSettings will return the Merge Request Approval settings
 // for a project by looking up it's namespace in the config.
-func (c *Config) MergeRequestApprovalSettings(ns string) *gitlab.ProjectApprovals {
-	for {
-		// Loop through groups and return configured settings if found.
-		for _, g := range c.Groups {
-			if strings.EqualFold(g.Name, ns) {
-				return &g.General.MergeRequestApprovals
-			}
-		}
+func (c *Config) MergeRequestApprovalSettings(ns string) *MergeRequestApprovalSettings {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
 
-		// Pop last name in namespace before trying again.
-		s := strings.Split(ns, "/")
-		if len(s) == 1 {
-			break
-		}
-		ns = strings.Join(s[:len(s)-1], "/")
+	return &g.General.MergeRequestApprovals
+}
+
+// ContainerExpirationPolicySettings will return the container registry cleanup
+// policy for a project by looking up it's namespace in the config.
+func (c *Config) ContainerExpirationPolicySettings(ns string) *ContainerExpirationPolicySettings {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
 	}
 
-	// Return nil if we didn't find config for this setting.
-	return nil
+	return &g.Packages.ContainerExpirationPolicy
+}
+
+// DefaultBranchSettings will return the default branch for a project by looking
+// up it's namespace in the config.
+func (c *Config) DefaultBranchSettings(ns string) string {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return ""
+	}
+
+	return g.Repository.DefaultBranch
+}
+
+// FeaturesSettings will return the visibility and feature settings for a
+// project by looking up it's namespace in the config.
+func (c *Config) FeaturesSettings(ns string) *FeaturesSettings {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
+
+	return &g.General.Features
 }
 
 // ProtectedBranchesSettings will return the Protected Branches settings
 // for a project by looking up it's namespace in the config.
 func (c *Config) ProtectedBranchesSettings(ns string) []*ProtectedBranchSetting {
-	for {
-		// Loop through groups and return configured settings if found.
-		for _, g := range c.Groups {
-			if strings.EqualFold(g.Name, ns) {
-				return g.Repository.ProtectedBranches
-			}
-		}
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
 
-		// Pop last name in namespace before trying again.
-		s := strings.Split(ns, "/")
-		if len(s) == 1 {
-			break
-		}
-		ns = strings.Join(s[:len(s)-1], "/")
+	return g.Repository.ProtectedBranches
+}
+
+// MirrorsSettings will return the push mirrors for a project by looking up it's
+// namespace in the config.
+func (c *Config) MirrorsSettings(ns string) []*MirrorSetting {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
 	}
 
-	// Return nil if we didn't find config for this setting.
-	return nil
+	return g.Repository.Mirrors
 }
 
 // SlackSettings will return the Slack settings for a project by looking up
 // it's namespace in the config.
 func (c *Config) SlackSettings(ns string) *SlackSettings {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
+
+	return &g.Integrations.Slack
+}
+
+// BadgesSettings will return the badges for a project by looking up it's
+// namespace in the config.
+func (c *Config) BadgesSettings(ns string) []*BadgeSetting {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
+
+	return g.General.Badges
+}
+
+// CIGeneralSettings will return the general CI/CD pipeline settings for a
+// project by looking up it's namespace in the config.
+func (c *Config) CIGeneralSettings(ns string) *CIGeneralSettings {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
+
+	return &g.CI.General
+}
+
+// CISchedulesSettings will return the pipeline schedules for a project by
+// looking up it's namespace in the config.
+func (c *Config) CISchedulesSettings(ns string) []*ScheduleSetting {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
+
+	return g.CI.Schedules
+}
+
+// CIVariablesSettings will return the CI/CD variables for a project by looking
+// up it's namespace in the config.
+func (c *Config) CIVariablesSettings(ns string) []*VariableSetting {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
+
+	return g.CI.Variables
+}
+
+// DeployKeysSettings will return the deploy keys for a project by looking up
+// it's namespace in the config.
+func (c *Config) DeployKeysSettings(ns string) []*DeployKeySetting {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
+
+	return g.Repository.DeployKeys
+}
+
+// DeployTokensSettings will return the deploy tokens for a project, and the
+// output created tokens are written to, by looking up it's namespace in the
+// config.
+func (c *Config) DeployTokensSettings(ns string) ([]*DeployTokenSetting, string) {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil, ""
+	}
+
+	return g.Repository.DeployTokens, g.Repository.DeployTokensOutput
+}
+
+// FilesSettings will return the managed files for a project, and the branch
+// used to propose changes to them, by looking up it's namespace in the config.
+func (c *Config) FilesSettings(ns string) ([]*FileSetting, string) {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil, ""
+	}
+
+	return g.Repository.Files, g.Repository.FilesBranch
+}
+
+// JiraSettings will return the Jira settings for a project by looking up
+// it's namespace in the config.
+func (c *Config) JiraSettings(ns string) *JiraSettings {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
+
+	return &g.Integrations.Jira
+}
+
+// TemplatesSettings will return the description templates for a project by
+// looking up it's namespace in the config.
+func (c *Config) TemplatesSettings(ns string) *TemplatesSettings {
+	g := c.groupSettings(ns)
+	if g == nil {
+		return nil
+	}
+
+	return &g.Templates
+}
+
+// groupSettings will return the settings of the group closest to a project or
+// namespace, popping the last name off the path until a configured group is
+// found. Overrides configured for the project in that group are applied on top.
+func (c *Config) groupSettings(path string) *Settings {
+	ns := path
 	for {
 		// Loop through groups and return configured settings if found.
 		for _, g := range c.Groups {
 			if strings.EqualFold(g.Name, ns) {
-				return &g.Integrations.Slack
+				return g.withOverrides(path)
 			}
 		}
 
@@ -141,10 +362,57 @@ func (c *Config) SlackSettings(ns string) *SlackSettings {
 		ns = strings.Join(s[:len(s)-1], "/")
 	}
 
-	// Return nil if we didn't find config for this setting.
+	// Return nil if we didn't find config for this namespace.
 	return nil
 }
 
+// withOverrides returns the group's settings with the overrides of a project
+// applied, or the group's settings as they are if the project has none. Project
+// names can be relative to the group or a full path.
+func (g *Settings) withOverrides(path string) *Settings {
+	for _, o := range g.Projects {
+		if strings.EqualFold(o.Name, path) || strings.EqualFold(g.Name+"/"+o.Name, path) {
+			return g.override(o)
+		}
+	}
+
+	return g
+}
+
+// override returns a copy of the settings where each setting that is set in o
+// replaces the value of the same setting as a whole. As with mergo, a setting
+// can't be overridden with an empty value, as an empty value can't be told apart
+// from one that isn't set; an override can't switch a boolean off, clear a list
+// or unset a string.
+func (g *Settings) override(o *Settings) *Settings {
+	merged := *g
+
+	mv := reflect.ValueOf(&merged).Elem()
+	ov := reflect.ValueOf(o).Elem()
+	for i := 0; i < mv.NumField(); i++ {
+		switch mv.Type().Field(i).Name {
+		case "Name", "Projects":
+			continue
+		}
+
+		// Sections such as General and Repository are overridden per setting.
+		if ov.Field(i).Kind() == reflect.Struct {
+			for j := 0; j < ov.Field(i).NumField(); j++ {
+				if !ov.Field(i).Field(j).IsZero() {
+					mv.Field(i).Field(j).Set(ov.Field(i).Field(j))
+				}
+			}
+			continue
+		}
+
+		if !ov.Field(i).IsZero() {
+			mv.Field(i).Set(ov.Field(i))
+		}
+	}
+
+	return &merged
+}
+
 // newClient returns a configured GitLab client.
 func newClient(token, url string) (*gitlab.Client, error) {
 	client, err := gitlab.NewClient(token, gitlab.WithBaseURL(url))
@@ -160,3 +428,36 @@ func newClient(token, url string) (*gitlab.Client, error) {
 func compareObjects(a, b interface{}) bool {
 	return reflect.DeepEqual(a, b)
 }
+
+// exactMode returns true if a settings block uses the "exact" mode, in which any
+// event or boolean not listed in the config is switched off. The default
+// "additive" mode only ensures what is listed is switched on.
+func exactMode(mode string) (bool, error) {
+	switch strings.ToLower(mode) {
+	case "", "additive":
+		return false, nil
+	case "exact":
+		return true, nil
+	}
+
+	return false, fmt.Errorf("Invalid mode \"%s\"; supported modes are \"additive\" and \"exact\"", mode)
+}
+
+// printf writes progress to the config's output.
+func (c *Config) printf(format string, a ...interface{}) {
+	w := c.Output
+	if w == nil {
+		w = os.Stdout
+	}
+	fmt.Fprintf(w, format, a...)
+}
+
+// contains checks a slice for a string and returns true if found.
+func contains(s []string, str string) bool {
+	for _, n := range s {
+		if str == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// fakeGitLab is a stub GitLab API that answers requests with canned responses and
+// records the requests it receives.
+type fakeGitLab struct {
+	// responses maps "METHOD /path" to the JSON returned for it; paths are relative
+	// to the API root. Requests without a response get a 404.
+	responses map[string]string
+	requests  []fakeRequest
+
+	// statuses maps "METHOD /path" to the status code returned for it, if it isn't
+	// 200.
+	statuses map[string]int
+}
+
+// fakeRequest is a request received by a fakeGitLab.
+type fakeRequest struct {
+	Method string
+	Path   string
+	Body   string
+}
+
+// newFakeGitLab starts a stub GitLab API and returns a client for it, along with
+// a config for a single "acme" group that writes snapshots to a temporary
+// directory and discards progress.
+func newFakeGitLab(t *testing.T, responses map[string]string) (*fakeGitLab, *gitlab.Client, *Config) {
+	f := &fakeGitLab{responses: responses, statuses: map[string]int{}}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		path := strings.TrimPrefix(r.URL.Path, "/api/v4")
+		f.requests = append(f.requests, fakeRequest{Method: r.Method, Path: path, Body: string(body)})
+
+		w.Header().Set("Content-Type", "application/json")
+		resp, ok := f.responses[r.Method+" "+path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			resp = `{"message":"404 Not Found"}`
+		} else if status, ok := f.statuses[r.Method+" "+path]; ok {
+			w.WriteHeader(status)
+		}
+		w.Write([]byte(resp))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := gitlab.NewClient("token", gitlab.WithBaseURL(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Groups:      []*Settings{{Name: "acme"}},
+		RunID:       "test",
+		SnapshotDir: t.TempDir(),
+		Output:      ioutil.Discard,
+	}
+
+	return f, client, cfg
+}
+
+// writes returns the requests that would change something, as "METHOD /path".
+func (f *fakeGitLab) writes() []string {
+	writes := []string{}
+	for _, r := range f.requests {
+		if r.Method != http.MethodGet {
+			writes = append(writes, r.Method+" "+r.Path)
+		}
+	}
+
+	return writes
+}
+
+// request returns the last request received for "METHOD /path".
+func (f *fakeGitLab) request(t *testing.T, route string) fakeRequest {
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if f.requests[i].Method+" "+f.requests[i].Path == route {
+			return f.requests[i]
+		}
+	}
+	t.Fatalf("no %s request received", route)
+
+	return fakeRequest{}
+}
+
+// testProject is the project handlers are tested against.
+var testProject = &gitlab.Project{ID: 1, Name: "api", PathWithNamespace: "acme/api"}
+
+// onlyCheck returns the only check recorded for a setting.
+func onlyCheck(t *testing.T, cfg *Config, setting string) *Check {
+	var found *Check
+	for _, c := range cfg.checks {
+		if c.Setting != setting {
+			continue
+		}
+		if found != nil {
+			t.Fatalf("more than one %s check recorded", setting)
+		}
+		found = c
+	}
+	if found == nil {
+		t.Fatalf("no %s check recorded", setting)
+	}
+
+	return found
+}
+
+func TestGroupSettings(t *testing.T) {
+	group := &Settings{Name: "acme"}
+	group.General.Features.Visibility = "private"
+	group.Repository.DefaultBranch = "main"
+	group.Repository.FilesBranch = "repo-settings"
+	group.Issues.Labels = []*LabelSetting{{Name: "bug"}}
+	group.Issues.RemoveUnlistedLabels = true
+
+	relative := &Settings{Name: "web"}
+	relative.General.Features.IssuesAccessLevel = "disabled"
+	relative.Repository.DefaultBranch = "develop"
+	relative.Issues.Labels = []*LabelSetting{{Name: "feature"}}
+
+	full := &Settings{Name: "acme/sub/docs"}
+	full.Repository.DefaultBranch = "pages"
+
+	group.Projects = []*Settings{relative, full}
+	cfg := &Config{Groups: []*Settings{group}}
+
+	tests := []struct {
+		path             string
+		wantNil          bool
+		wantBranch       string
+		wantFilesBranch  string
+		wantVisibility   string
+		wantIssuesAccess string
+		wantLabel        string
+	}{
+		{path: "acme/api", wantBranch: "main", wantFilesBranch: "repo-settings", wantVisibility: "private", wantLabel: "bug"},
+		{path: "acme/sub/api", wantBranch: "main", wantFilesBranch: "repo-settings", wantVisibility: "private", wantLabel: "bug"},
+		// A setting is overridden as a whole, so the override's features replace
+		// the group's, while settings it doesn't set are kept.
+		{path: "acme/web", wantBranch: "develop", wantFilesBranch: "repo-settings", wantIssuesAccess: "disabled", wantLabel: "feature"},
+		{path: "ACME/Web", wantBranch: "develop", wantFilesBranch: "repo-settings", wantIssuesAccess: "disabled", wantLabel: "feature"},
+		{path: "acme/sub/docs", wantBranch: "pages", wantFilesBranch: "repo-settings", wantVisibility: "private", wantLabel: "bug"},
+		{path: "other/web", wantNil: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := cfg.groupSettings(tt.path)
+			if tt.wantNil {
+				if got != nil {
+					t.Fatalf("groupSettings() = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("groupSettings() = nil")
+			}
+
+			if got.Repository.DefaultBranch != tt.wantBranch {
+				t.Errorf("default branch = %q, want %q", got.Repository.DefaultBranch, tt.wantBranch)
+			}
+			if got.Repository.FilesBranch != tt.wantFilesBranch {
+				t.Errorf("files branch = %q, want %q", got.Repository.FilesBranch, tt.wantFilesBranch)
+			}
+			if got.General.Features.Visibility != tt.wantVisibility {
+				t.Errorf("visibility = %q, want %q", got.General.Features.Visibility, tt.wantVisibility)
+			}
+			if got.General.Features.IssuesAccessLevel != tt.wantIssuesAccess {
+				t.Errorf("issues access level = %q, want %q", got.General.Features.IssuesAccessLevel, tt.wantIssuesAccess)
+			}
+			if len(got.Issues.Labels) != 1 || got.Issues.Labels[0].Name != tt.wantLabel {
+				t.Errorf("labels = %v, want [%s]", got.Issues.Labels, tt.wantLabel)
+			}
+		})
+	}
+
+	// An override can't switch a boolean off, as false can't be told apart from a
+	// value that isn't set.
+	if !cfg.groupSettings("acme/web").Issues.RemoveUnlistedLabels {
+		t.Errorf("override switched remove unlisted labels off")
+	}
+
+	// Overrides must not change the group's own settings.
+	if group.Repository.DefaultBranch != "main" || group.General.Features.Visibility != "private" {
+		t.Errorf("group settings were changed by an override")
+	}
+}
@@ -18,7 +18,6 @@ package gitlab
 
 import (
 	"fmt"
-	"log"
 	"regexp"
 
 	"github.com/xanzy/go-gitlab"
@@ -57,7 +56,7 @@ func listGroups(client *gitlab.Client) (Groups, error) {
 	for {
 		gs, resp, err := client.Groups.ListGroups(opt)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
 		groups = append(groups, gs...)
@@ -72,10 +71,48 @@ func listGroups(client *gitlab.Client) (Groups, error) {
 	return groups, nil
 }
 
+// listGroupProjects returns a slice containing all unarchived projects in a
+// group and it's subgroups.
+func listGroupProjects(client *gitlab.Client, gid int) ([]*gitlab.Project, error) {
+	projects := []*gitlab.Project{}
+
+	archived := false
+	includeSubGroups := true
+	orderBy := "name"
+	sort := "asc"
+	opt := &gitlab.ListGroupProjectsOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 20,
+			Page:    1,
+		},
+		Archived:         &archived,
+		IncludeSubGroups: &includeSubGroups,
+		OrderBy:          &orderBy,
+		Sort:             &sort,
+	}
+
+	for {
+		ps, resp, err := client.Groups.ListGroupProjects(gid, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		projects = append(projects, ps...)
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		opt.Page = resp.NextPage
+	}
+
+	return projects, nil
+}
+
 // UpdateProjectsInGroups is the entry point for this package and will update any projects
 // found within the groups defined in *Config.Groups.
 func UpdateProjectsInGroups(cfg *Config) error {
-	client, err := newClient(*cfg.APIToken, *cfg.APIURL)
+	client, err := newAuditedClient(cfg)
 	if err != nil {
 		return err
 	}
@@ -85,59 +122,126 @@ func UpdateProjectsInGroups(cfg *Config) error {
 		return err
 	}
 
+	// Settings are snapshotted before they're changed, so the run can be rolled back.
+	if cfg.RunID == "" {
+		cfg.RunID = NewRunID()
+	}
+	if !cfg.DryRun {
+		cfg.printf("Starting run %s, changed settings are snapshotted to %s\n", cfg.RunID, snapshotPath(cfg.snapshotDir(), cfg.RunID))
+	}
+
 	for _, g := range cfg.Groups {
-		fmt.Printf("Looking up group with name \"%s\" ... ", g.Name)
+		cfg.printf("Looking up group with name \"%s\" ... ", g.Name)
 		id, err := groups.getID(g.Name)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("matched group to ID %d\n", id)
-
-		archived := false
-		includeSubGroups := true
-		orderBy := "name"
-		sort := "asc"
-		opt := &gitlab.ListGroupProjectsOptions{
-			ListOptions: gitlab.ListOptions{
-				PerPage: 20,
-				Page:    1,
-			},
-			Archived:         &archived,
-			IncludeSubGroups: &includeSubGroups,
-			OrderBy:          &orderBy,
-			Sort:             &sort,
-		}
+		cfg.printf("matched group to ID %d\n", id)
 
-		for {
-			ps, resp, err := client.Groups.ListGroupProjects(id, opt)
-			if err != nil {
-				log.Fatal(err)
-			}
+		projects, err := listGroupProjects(client, id)
+		if err != nil {
+			return err
+		}
 
-			for _, p := range ps {
-				// General settings
-				// - Update Merge Request Approval settings
-				if err := updateMergeRequestAppovalsSettings(client, p, cfg); err != nil {
-					return err
-				}
-				// Repository settings
-				// - Protected Branch settings
-				if err := updateProtectedBranchesSettings(client, p, cfg); err != nil {
-					return err
-				}
-				// Integrations
-				// - Update Slack integration
-				if err := updateSlackService(client, p, cfg); err != nil {
-					return err
-				}
+		for _, p := range projects {
+			if err := updateProject(client, p, cfg); err != nil {
+				return err
 			}
+		}
+	}
 
-			if resp.CurrentPage >= resp.TotalPages {
-				break
-			}
+	return nil
+}
 
-			opt.Page = resp.NextPage
-		}
+// updateProject applies the settings of the group closest to a project to it.
+func updateProject(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// General settings
+	// - Update Visibility and feature settings
+	if err := updateFeaturesSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// - Update Badges
+	if err := updateBadgesSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// - Update Merge Request Approval settings
+	if err := updateMergeRequestAppovalsSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// Members
+	if err := updateMembersSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// Repository settings
+	// - Default Branch, updated first so protections can follow it
+	if err := updateDefaultBranchSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// - Protected Branch settings
+	if err := updateProtectedBranchesSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// - Deploy keys
+	if err := updateDeployKeysSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// - Deploy tokens
+	if err := updateDeployTokensSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// - Mirroring repositories
+	if err := updateMirrorsSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// - Managed files
+	if err := updateFilesSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// Issues settings
+	// - Labels
+	if err := updateLabelsSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// Templates
+	// - Default descriptions and template files
+	if err := updateTemplatesSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// CI/CD settings
+	// - General pipelines settings
+	if err := updateCIGeneralSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// - CI/CD variables
+	if err := updateCIVariablesSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// - Pipeline schedules
+	if err := updateCISchedulesSettings(client, p, cfg); err != nil {
+		return err
+	}
+	// Packages and registries settings
+	// - Container Registry cleanup policy
+	if err := updateContainerExpirationPolicySettings(client, p, cfg); err != nil {
+		return err
+	}
+	// Integrations
+	// - Update Slack integration
+	if err := updateSlackService(client, p, cfg); err != nil {
+		return err
+	}
+	// - Update Jira integration
+	if err := updateJiraService(client, p, cfg); err != nil {
+		return err
+	}
+	// - Update integrations described in the integrations table
+	if err := updateIntegrations(client, p, cfg); err != nil {
+		return err
+	}
+	// Lifecycle rules, applied last as they may rename, move or
+	// archive the project
+	if err := updateLifecycleSettings(client, p, cfg); err != nil {
+		return err
 	}
 
 	return nil
@@ -0,0 +1,496 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// IntegrationSettings represents a project's settings for an integration that is
+// managed using the integrations table. Properties are named after their API
+// parameters; secrets are properties whose values are read from a secret source.
+type IntegrationSettings struct {
+	Mode       string                 `json:"mode,omitempty"`
+	Active     bool                   `json:"active"`
+	Events     []string               `json:"events"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	Secrets    map[string]*Secret     `json:"secrets,omitempty"`
+}
+
+// integrationSchema describes how an integration's settings map onto the GitLab
+// API. Boolean properties are switched off in exact mode when they aren't set.
+// Write-only properties are never returned by the API, so they can't be compared
+// and are only sent when other settings need updating.
+type integrationSchema struct {
+	name       string
+	slug       string
+	events     []string
+	properties []string
+	booleans   []string
+	secrets    []string
+	writeOnly  []string
+	settings   func(s *Settings) *IntegrationSettings
+}
+
+// integrationEvents maps the event names used in the config file to the API
+// parameters that enable them.
+var integrationEvents = map[string]string{
+	"alert":               "alert_events",
+	"commit":              "commit_events",
+	"confidential_issues": "confidential_issues_events",
+	"confidential_note":   "confidential_note_events",
+	"deployment":          "deployment_events",
+	"issues":              "issues_events",
+	"job":                 "job_events",
+	"merge_request":       "merge_requests_events",
+	"note":                "note_events",
+	"pipeline":            "pipeline_events",
+	"push":                "push_events",
+	"tags":                "tag_push_events",
+	"vulnerability":       "vulnerability_events",
+	"wiki_page":           "wiki_page_events",
+}
+
+// integrations lists the integrations managed by updateIntegrations. To support
+// a new integration add it's settings to Settings.Integrations and describe it
+// here.
+var integrations = []integrationSchema{
+	{
+		name:       "Datadog",
+		slug:       "datadog",
+		properties: []string{"api_url", "archive_trace_events", "datadog_ci_visibility", "datadog_env", "datadog_service", "datadog_site", "datadog_tags"},
+		booleans:   []string{"archive_trace_events", "datadog_ci_visibility"},
+		secrets:    []string{"api_key"},
+		settings:   func(s *Settings) *IntegrationSettings { return &s.Integrations.Datadog },
+	},
+	{
+		name:       "Discord",
+		slug:       "discord",
+		events:     append([]string{"deployment"}, chatEvents...),
+		properties: chatProperties,
+		booleans:   chatBooleans,
+		writeOnly:  []string{"webhook"},
+		settings:   func(s *Settings) *IntegrationSettings { return s.Integrations.Discord.integrationSettings() },
+	},
+	{
+		name:       "Emails on Push",
+		slug:       "emails-on-push",
+		events:     []string{"push", "tags"},
+		properties: []string{"branches_to_be_notified", "disable_diffs", "recipients", "send_from_committer_email"},
+		booleans:   []string{"disable_diffs", "send_from_committer_email"},
+		settings:   func(s *Settings) *IntegrationSettings { return &s.Integrations.EmailsOnPush },
+	},
+	{
+		name:       "Google Chat",
+		slug:       "hangouts-chat",
+		events:     chatEvents,
+		properties: chatProperties,
+		booleans:   chatBooleans,
+		writeOnly:  []string{"webhook"},
+		settings:   func(s *Settings) *IntegrationSettings { return s.Integrations.GoogleChat.integrationSettings() },
+	},
+	{
+		name:       "Mattermost",
+		slug:       "mattermost",
+		events:     chatEvents,
+		properties: append([]string{"channel", "username"}, chatProperties...),
+		booleans:   chatBooleans,
+		writeOnly:  []string{"webhook"},
+		settings:   func(s *Settings) *IntegrationSettings { return s.Integrations.Mattermost.integrationSettings() },
+	},
+	{
+		name:       "Microsoft Teams",
+		slug:       "microsoft-teams",
+		events:     chatEvents,
+		properties: chatProperties,
+		booleans:   chatBooleans,
+		writeOnly:  []string{"webhook"},
+		settings:   func(s *Settings) *IntegrationSettings { return s.Integrations.MicrosoftTeams.integrationSettings() },
+	},
+	{
+		name:       "Pipeline status emails",
+		slug:       "pipelines-email",
+		events:     []string{"pipeline"},
+		properties: []string{"branches_to_be_notified", "notify_only_broken_pipelines", "recipients"},
+		booleans:   []string{"notify_only_broken_pipelines"},
+		settings:   func(s *Settings) *IntegrationSettings { return &s.Integrations.PipelinesEmail },
+	},
+	{
+		name:       "Prometheus",
+		slug:       "prometheus",
+		properties: []string{"api_url", "google_iap_audience_client_id"},
+		secrets:    []string{"google_iap_service_account_json"},
+		settings:   func(s *Settings) *IntegrationSettings { return &s.Integrations.Prometheus },
+	},
+}
+
+func updateIntegrations(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	for _, schema := range integrations {
+		if err := updateIntegration(client, p, cfg, schema); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func updateIntegration(client *gitlab.Client, p *gitlab.Project, cfg *Config, schema integrationSchema) error {
+	// Fetch the integration's settings from config file and return if nothing is configured.
+	g := cfg.groupSettings(p.PathWithNamespace)
+	if g == nil {
+		return nil
+	}
+	cfgSettings := schema.settings(g)
+	if compareObjects(cfgSettings, &IntegrationSettings{}) {
+		return nil
+	}
+
+	// Build the API parameters for our desired settings; anything we don't define in our
+	// config is left as it is. Secrets are resolved first so a missing secret is also
+	// reported during a dry run, but not for a report.
+	newSettings, err := schema.params(cfg, cfgSettings)
+	if err != nil {
+		return err
+	}
+
+	// Fetch current integration settings and properties.
+	projectSettings, err := getIntegration(client, p.ID, schema.slug)
+	if err != nil {
+		cfg.fetchFailed(p, schema.name, err)
+		return nil
+	}
+	active := normaliseValue(projectSettings["active"]) == "true"
+
+	// Integrations are disabled rather than removed so their settings are kept, and
+	// there is nothing else to compare when an integration should be inactive.
+	if !cfgSettings.Active {
+		cfg.check(p, schema.name, !active, "active: true -> false")
+
+		if !active {
+			cfg.printf("Project %s's %s settings don't need updating\n", p.PathWithNamespace, schema.name)
+			return nil
+		}
+
+		cfg.printf("Project %s's %s integration needs disabling ... ", p.PathWithNamespace, schema.name)
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			return nil
+		}
+
+		audit, err := cfg.snapshot(p, "integration", schema.slug, true, projectSettings.settings(schema.writeOnlyParams()), schema.writeOnlyParams()...)
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		newSettings["active"] = false
+		if err := setIntegration(client, p.ID, schema.slug, newSettings, audit); err != nil {
+			cfg.updated(p, schema.name, err)
+			return err
+		}
+		cfg.updated(p, schema.name, nil)
+		cfg.printf("Success!\n")
+
+		return nil
+	}
+
+	// Return if our proposed config matches the actual config
+	if active && projectSettings.matches(newSettings, schema.writeOnlyParams()) {
+		if unverifiable := schema.unverifiable(cfgSettings); len(unverifiable) > 0 {
+			cfg.unverifiable(p, schema.name, fmt.Sprintf("%s: not returned by GitLab", strings.Join(unverifiable, ", ")))
+		} else {
+			cfg.check(p, schema.name, true, "")
+		}
+		cfg.printf("Project %s's %s settings don't need updating\n", p.PathWithNamespace, schema.name)
+		return nil
+	}
+
+	if !active {
+		cfg.check(p, schema.name, false, "active: false -> true")
+	} else {
+		cfg.check(p, schema.name, false, projectSettings.differences(newSettings, schema.writeOnlyParams()))
+	}
+
+	cfg.printf("Project %s's %s settings need updating ... ", p.PathWithNamespace, schema.name)
+
+	if cfg.DryRun {
+		cfg.printf("skipping because this is a dry run\n")
+		return nil
+	}
+
+	audit, err := cfg.snapshot(p, "integration", schema.slug, len(projectSettings) > 0, projectSettings.settings(schema.writeOnlyParams()), schema.writeOnlyParams()...)
+	if err != nil {
+		return err
+	}
+
+	cfg.printf("Updating project ... ")
+
+	if err := setIntegration(client, p.ID, schema.slug, newSettings, audit); err != nil {
+		cfg.updated(p, schema.name, err)
+		return err
+	}
+	cfg.updated(p, schema.name, nil)
+	cfg.printf("Success!\n")
+
+	return nil
+}
+
+// params returns the API parameters for an integration's settings, skipping any
+// events or properties the integration doesn't support.
+func (schema integrationSchema) params(cfg *Config, s *IntegrationSettings) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+
+	// In exact mode the config is the full desired state, so we start with every
+	// event and boolean switched off.
+	exact, err := exactMode(s.Mode)
+	if err != nil {
+		return nil, err
+	}
+	if exact {
+		for _, e := range schema.events {
+			params[integrationEvents[e]] = false
+		}
+		for _, b := range schema.booleans {
+			params[b] = false
+		}
+	}
+
+	for _, e := range s.Events {
+		if !contains(schema.events, e) {
+			cfg.printf("Unsupported %s event type: %s\n", schema.name, e)
+			continue
+		}
+		params[integrationEvents[e]] = true
+	}
+
+	for _, k := range sortedKeys(s.Properties) {
+		if !contains(schema.properties, k) {
+			cfg.printf("Unsupported %s property: %s\n", schema.name, k)
+			continue
+		}
+		params[k] = s.Properties[k]
+	}
+
+	for k, secret := range s.Secrets {
+		if !contains(schema.secrets, k) {
+			cfg.printf("Unsupported %s secret: %s\n", schema.name, k)
+			continue
+		}
+		if cfg.report {
+			continue
+		}
+		v, err := secret.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		params[k] = v
+	}
+
+	return params, nil
+}
+
+// writeOnlyParams returns the parameters the API never returns, including secrets.
+func (schema integrationSchema) writeOnlyParams() []string {
+	params := []string{}
+	params = append(params, schema.writeOnly...)
+	return append(params, schema.secrets...)
+}
+
+// unverifiable returns the configured parameters that can't be compared with the
+// integration's current settings, as the API never returns them.
+func (schema integrationSchema) unverifiable(s *IntegrationSettings) []string {
+	params := []string{}
+	for k := range s.Secrets {
+		if contains(schema.secrets, k) {
+			params = append(params, k)
+		}
+	}
+	for k := range s.Properties {
+		if contains(schema.writeOnly, k) {
+			params = append(params, k)
+		}
+	}
+	sort.Strings(params)
+
+	return params
+}
+
+// integration represents an integration as returned by the GitLab API, where the
+// integration specific properties are nested under the "properties" key.
+type integration map[string]interface{}
+
+// getIntegration fetches an integration's settings using it's slug.
+func getIntegration(client *gitlab.Client, pid int, slug string) (integration, error) {
+	req, err := client.NewRequest(http.MethodGet, fmt.Sprintf("projects/%d/integrations/%s", pid, slug), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	i := integration{}
+	resp, err := client.Do(req, &i)
+	if err != nil {
+		// An integration that has never been set up has no settings yet.
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return integration{}, nil
+		}
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// setIntegration updates an integration's settings using it's slug, which also
+// enables it unless "active" is set to false. The settings are either a map of
+// API parameters or a struct that encodes to them.
+func setIntegration(client *gitlab.Client, pid int, slug string, settings interface{}, options ...gitlab.RequestOptionFunc) error {
+	req, err := client.NewRequest(http.MethodPut, fmt.Sprintf("projects/%d/integrations/%s", pid, slug), settings, options)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Do(req, nil)
+	return err
+}
+
+// deleteIntegration removes an integration's settings using it's slug.
+func deleteIntegration(client *gitlab.Client, pid int, slug string, options ...gitlab.RequestOptionFunc) error {
+	req, err := client.NewRequest(http.MethodDelete, fmt.Sprintf("projects/%d/integrations/%s", pid, slug), nil, options)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Do(req, nil)
+	return err
+}
+
+// settings returns whether the integration is active, and it's events and
+// properties, as API parameters, leaving out write-only parameters as the API
+// never returns them.
+func (i integration) settings(writeOnly []string) map[string]interface{} {
+	settings := map[string]interface{}{}
+	for k, v := range i {
+		if k == "active" || strings.HasSuffix(k, "_events") {
+			settings[k] = v
+		}
+	}
+	if props, ok := i["properties"].(map[string]interface{}); ok {
+		for k, v := range props {
+			if !contains(writeOnly, k) {
+				settings[k] = v
+			}
+		}
+	}
+
+	return settings
+}
+
+// restoreIntegration restores whether an integration is active and it's events
+// and properties, or removes it if it wasn't set up. Write-only parameters such as
+// secrets can't be restored, as the API never returns them.
+func restoreIntegration(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	if !s.Existed {
+		return deleteIntegration(client, s.ProjectID, s.Name, audit)
+	}
+
+	settings := map[string]interface{}{}
+	if err := json.Unmarshal(s.Before, &settings); err != nil {
+		return err
+	}
+
+	return setIntegration(client, s.ProjectID, s.Name, settings, audit)
+}
+
+// get returns the current value of an API parameter, looking at both the generic
+// integration settings and it's properties.
+func (i integration) get(key string) (interface{}, bool) {
+	if v, ok := i[key]; ok {
+		return v, true
+	}
+	if props, ok := i["properties"].(map[string]interface{}); ok {
+		if v, ok := props[key]; ok {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+// matches returns true if the integration already has the settings we want.
+// Write-only parameters are skipped as the API never returns them.
+func (i integration) matches(settings map[string]interface{}, writeOnly []string) bool {
+	for k, v := range settings {
+		if contains(writeOnly, k) {
+			continue
+		}
+		current, ok := i.get(k)
+		if !ok || normaliseValue(current) != normaliseValue(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// differences returns the settings that differ from the integration's current
+// settings, as "key: current -> wanted" pairs.
+func (i integration) differences(settings map[string]interface{}, writeOnly []string) string {
+	diffs := []string{}
+	for _, k := range sortedKeys(settings) {
+		if contains(writeOnly, k) {
+			continue
+		}
+		current, ok := i.get(k)
+		if !ok || normaliseValue(current) != normaliseValue(settings[k]) {
+			diffs = append(diffs, diffValue(k, normaliseValue(current), normaliseValue(settings[k])))
+		}
+	}
+
+	return strings.Join(diffs, ", ")
+}
+
+// normaliseValue returns a string representation of an API value, as boolean
+// properties may be returned as booleans, "true"/"false" or "1"/"0".
+func normaliseValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	switch s {
+	case "1", "true":
+		return "true"
+	case "0", "false", "<nil>":
+		return "false"
+	}
+
+	return s
+}
+
+// sortedKeys returns the keys of a map in alphabetical order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
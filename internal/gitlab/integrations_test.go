@@ -0,0 +1,116 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import "testing"
+
+// currentIntegration is an integration as returned by the API; boolean
+// properties may be returned as strings.
+var currentIntegration = integration{
+	"active":      true,
+	"push_events": false,
+	"properties": map[string]interface{}{
+		"notify_only_broken_pipelines": "1",
+		"channel":                      "#builds",
+	},
+}
+
+func TestIntegrationMatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		settings  map[string]interface{}
+		writeOnly []string
+		want      bool
+	}{
+		{
+			name:     "matching settings and properties",
+			settings: map[string]interface{}{"active": true, "channel": "#builds"},
+			want:     true,
+		},
+		{
+			name:     "normalised booleans",
+			settings: map[string]interface{}{"push_events": "0", "notify_only_broken_pipelines": true},
+			want:     true,
+		},
+		{
+			name:     "changed values",
+			settings: map[string]interface{}{"active": true, "channel": "#deploys"},
+		},
+		{
+			name:     "missing values",
+			settings: map[string]interface{}{"username": "bot"},
+		},
+		{
+			name:      "write-only values are skipped",
+			settings:  map[string]interface{}{"active": true, "webhook": "https://hooks.example.com/abc"},
+			writeOnly: []string{"webhook"},
+			want:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := currentIntegration.matches(tt.settings, tt.writeOnly); got != tt.want {
+				t.Errorf("matches() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntegrationDifferences(t *testing.T) {
+	tests := []struct {
+		name      string
+		settings  map[string]interface{}
+		writeOnly []string
+		want      string
+	}{
+		{
+			name:     "matching settings",
+			settings: map[string]interface{}{"active": true, "push_events": "0", "channel": "#builds"},
+			want:     "",
+		},
+		{
+			name:     "changed values",
+			settings: map[string]interface{}{"active": true, "push_events": true, "channel": "#deploys"},
+			want:     "channel: #builds -> #deploys, push_events: false -> true",
+		},
+		{
+			name:     "missing values",
+			settings: map[string]interface{}{"username": "bot"},
+			want:     "username: false -> bot",
+		},
+		{
+			name:      "write-only values are skipped",
+			settings:  map[string]interface{}{"webhook": "https://hooks.example.com/abc"},
+			writeOnly: []string{"webhook"},
+			want:      "",
+		},
+		{
+			name:     "sensitive values are redacted",
+			settings: map[string]interface{}{"api_key": "abc"},
+			want:     "api_key: [redacted]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := currentIntegration.differences(tt.settings, tt.writeOnly); got != tt.want {
+				t.Errorf("differences() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
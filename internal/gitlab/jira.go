@@ -0,0 +1,225 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/imdario/mergo"
+	"github.com/jinzhu/copier"
+	"github.com/xanzy/go-gitlab"
+)
+
+// JiraSettings represents a project's Jira settings.
+type JiraSettings struct {
+	Mode       string         `json:"mode,omitempty"`
+	Active     bool           `json:"active"`
+	Events     []string       `json:"events"`
+	Properties JiraProperties `json:"properties,omitempty"`
+}
+
+// JiraProperties represents the Jira integration's properties. The password
+// (or API token) is read from a secret source.
+type JiraProperties struct {
+	URL                   string   `json:"url,omitempty"`
+	APIURL                string   `json:"api_url,omitempty"`
+	Username              string   `json:"username,omitempty"`
+	Password              *Secret  `json:"password,omitempty"`
+	TransitionIDs         []string `json:"transition_ids,omitempty"`
+	TransitionAutomatic   *bool    `json:"transition_automatic,omitempty"`
+	CommentOnEventEnabled *bool    `json:"comment_on_event_enabled,omitempty"`
+	IssuesEnabled         *bool    `json:"issues_enabled,omitempty"`
+}
+
+func updateJiraService(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch Jira Service settings from config file and return if a nil object is returned.
+	cfgSettings := cfg.JiraSettings(p.PathWithNamespace)
+	if cfgSettings == nil || compareObjects(cfgSettings, &JiraSettings{}) {
+		return nil
+	}
+	exact, err := exactMode(cfgSettings.Mode)
+	if err != nil {
+		return err
+	}
+
+	// Resolve the password first so a missing secret is also reported during a dry run,
+	// but not for a report so it can be made without access to it.
+	var password string
+	if cfgSettings.Properties.Password.IsSet() && !cfg.report {
+		if password, err = cfgSettings.Properties.Password.Resolve(); err != nil {
+			return err
+		}
+	}
+
+	// Fetch current Jira Service settings and properties.
+	projectSettings, _, err := client.Services.GetJiraService(p.ID)
+	if err != nil {
+		cfg.fetchFailed(p, "Jira", err)
+		return nil
+	}
+	if projectSettings.Properties == nil {
+		projectSettings.Properties = &gitlab.JiraServiceProperties{}
+	}
+
+	// Create a gitlab.JiraService object with our desired service and properties by
+	// taking what is current and applying our changes on top, thus we do not override
+	// any settings that we don't define in our config.
+	newSettings := &gitlab.JiraService{}
+	if err := copier.CopyWithOption(newSettings, projectSettings, copier.Option{DeepCopy: true}); err != nil {
+		return err
+	}
+	// copier copies a nil slice as an empty one, which would never match.
+	newSettings.Properties.ProjectKeys = projectSettings.Properties.ProjectKeys
+
+	cfgProperties := &gitlab.JiraServiceProperties{
+		URL:                   cfgSettings.Properties.URL,
+		APIURL:                cfgSettings.Properties.APIURL,
+		Username:              cfgSettings.Properties.Username,
+		JiraIssueTransitionID: strings.Join(cfgSettings.Properties.TransitionIDs, ","),
+	}
+	if err := mergo.Merge(newSettings.Properties, cfgProperties, mergo.WithOverride); err != nil {
+		return err
+	}
+
+	// Whether the service is active, it's events and comments are returned as part of
+	// the service rather than it's properties, so they are only set there.
+	newSettings.Active = cfgSettings.Active
+	// In exact mode any event or boolean not listed in the config is switched off.
+	if exact {
+		newSettings.CommitEvents = false
+		newSettings.MergeRequestsEvents = false
+		newSettings.CommentOnEventEnabled = false
+		newSettings.Properties.IssuesEnabled = false
+		newSettings.Properties.JiraIssueTransitionAutomatic = false
+	}
+	// Booleans are set separately as mergo won't override a value with false.
+	if cfgSettings.Properties.CommentOnEventEnabled != nil {
+		newSettings.CommentOnEventEnabled = *cfgSettings.Properties.CommentOnEventEnabled
+	}
+	if cfgSettings.Properties.IssuesEnabled != nil {
+		newSettings.Properties.IssuesEnabled = *cfgSettings.Properties.IssuesEnabled
+	}
+	if cfgSettings.Properties.TransitionAutomatic != nil {
+		newSettings.Properties.JiraIssueTransitionAutomatic = *cfgSettings.Properties.TransitionAutomatic
+	}
+	// Transition IDs are ignored by GitLab when automatic transitions are enabled.
+	if len(cfgSettings.Properties.TransitionIDs) > 0 {
+		newSettings.Properties.JiraIssueTransitionAutomatic = false
+	}
+	for _, e := range cfgSettings.Events {
+		switch e {
+		case "commit":
+			newSettings.CommitEvents = true
+		case "merge_request":
+			newSettings.MergeRequestsEvents = true
+		default:
+			cfg.printf("Unsupported event type: %s\n", e)
+		}
+	}
+
+	// Return if our proposed config matches the actual config; the password can't be
+	// compared as the API never returns it.
+	if compareObjects(projectSettings, newSettings) {
+		if cfgSettings.Properties.Password.IsSet() {
+			cfg.unverifiable(p, "Jira", "password: not returned by GitLab")
+		} else {
+			cfg.check(p, "Jira", true, "")
+		}
+		cfg.printf("Project %s's Jira settings don't need updating\n", p.PathWithNamespace)
+		return nil
+	}
+
+	cfg.check(p, "Jira", false, diffObjects(projectSettings, newSettings))
+
+	cfg.printf("Project %s's Jira settings need updating ... ", p.PathWithNamespace)
+
+	if cfg.DryRun {
+		cfg.printf("skipping because this is a dry run\n")
+		return nil
+	}
+
+	opts := &gitlab.SetJiraServiceOptions{}
+
+	propData, _ := json.Marshal(newSettings.Properties)
+	if err := json.Unmarshal(propData, &opts); err != nil {
+		return err
+	}
+
+	svcData, _ := json.Marshal(newSettings.Service)
+	if err := json.Unmarshal(svcData, &opts); err != nil {
+		return err
+	}
+
+	// The API never returns the password or the deprecated project key, so only send
+	// a password when we have one.
+	opts.Password = nil
+	opts.ProjectKey = nil
+	if password != "" {
+		opts.Password = &password
+	}
+	audit, err := cfg.snapshot(p, "jira", "", projectSettings.Active, projectSettings)
+	if err != nil {
+		return err
+	}
+
+	cfg.printf("Updating project ... ")
+
+	_, err = client.Services.SetJiraService(p.ID, opts, audit)
+	if err != nil {
+		cfg.updated(p, "Jira", err)
+		return err
+	}
+	cfg.updated(p, "Jira", nil)
+	cfg.printf("Success!\n")
+
+	return nil
+}
+
+// restoreJiraService restores a project's Jira service settings, or removes them
+// if the service wasn't active. The API never returns the password, so a
+// password changed during a run isn't restored.
+func restoreJiraService(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.JiraService{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	if !s.Existed {
+		_, err := client.Services.DeleteJiraService(s.ProjectID, audit)
+		return err
+	}
+
+	opts := &gitlab.SetJiraServiceOptions{}
+
+	propData, _ := json.Marshal(before.Properties)
+	if err := json.Unmarshal(propData, &opts); err != nil {
+		return err
+	}
+
+	svcData, _ := json.Marshal(before.Service)
+	if err := json.Unmarshal(svcData, &opts); err != nil {
+		return err
+	}
+
+	opts.Password = nil
+	opts.ProjectKey = nil
+
+	_, err := client.Services.SetJiraService(s.ProjectID, opts, audit)
+
+	return err
+}
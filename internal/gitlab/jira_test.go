@@ -0,0 +1,118 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdateJiraService(t *testing.T) {
+	// GitLab returns whether the service is active and it's events as part of the
+	// service, not it's properties.
+	current := `{
+		"active": true,
+		"commit_events": true,
+		"merge_requests_events": false,
+		"comment_on_event_enabled": true,
+		"properties": {
+			"url": "https://jira.example.com",
+			"username": "gitlab",
+			"jira_issue_transition_id": "11,21"
+		}
+	}`
+
+	tests := []struct {
+		name        string
+		events      []string
+		url         string
+		wantWrites  []string
+		wantDetails string
+	}{
+		{
+			name:       "matches",
+			events:     []string{"commit"},
+			url:        "https://jira.example.com",
+			wantWrites: []string{},
+		},
+		{
+			name:        "event missing",
+			events:      []string{"commit", "merge_request"},
+			url:         "https://jira.example.com",
+			wantWrites:  []string{"PUT /projects/1/integrations/jira"},
+			wantDetails: "merge_requests_events: false -> true",
+		},
+		{
+			name:        "url changed",
+			events:      []string{"commit"},
+			url:         "https://jira.example.org",
+			wantWrites:  []string{"PUT /projects/1/integrations/jira"},
+			wantDetails: "properties.url: https://jira.example.com -> https://jira.example.org",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client, cfg := newFakeGitLab(t, map[string]string{
+				"GET /projects/1/integrations/jira": current,
+				"PUT /projects/1/integrations/jira": current,
+			})
+			jira := &cfg.Groups[0].Integrations.Jira
+			jira.Active = true
+			jira.Events = tt.events
+			jira.Properties.URL = tt.url
+			jira.Properties.TransitionIDs = []string{"11", "21"}
+
+			if err := updateJiraService(client, testProject, cfg); err != nil {
+				t.Fatalf("updateJiraService() error = %v", err)
+			}
+
+			if got := f.writes(); !reflect.DeepEqual(got, tt.wantWrites) {
+				t.Errorf("writes = %v, want %v", got, tt.wantWrites)
+			}
+
+			check := onlyCheck(t, cfg, "Jira")
+			if check.Compliant != (tt.wantDetails == "") || check.Details != tt.wantDetails {
+				t.Errorf("check = %+v, want details %q", check, tt.wantDetails)
+			}
+			if len(tt.wantWrites) > 0 && !check.Updated {
+				t.Errorf("check wasn't marked as updated")
+			}
+		})
+	}
+
+	// The update keeps what the config doesn't define and sends the events at the
+	// top level.
+	f, client, cfg := newFakeGitLab(t, map[string]string{
+		"GET /projects/1/integrations/jira": current,
+		"PUT /projects/1/integrations/jira": current,
+	})
+	jira := &cfg.Groups[0].Integrations.Jira
+	jira.Active = true
+	jira.Events = []string{"merge_request"}
+
+	if err := updateJiraService(client, testProject, cfg); err != nil {
+		t.Fatalf("updateJiraService() error = %v", err)
+	}
+	body := f.request(t, "PUT /projects/1/integrations/jira").Body
+	for _, want := range []string{`"merge_requests_events":true`, `"commit_events":true`, `"url":"https://jira.example.com"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("request body %s doesn't contain %s", body, want)
+		}
+	}
+}
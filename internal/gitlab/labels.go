@@ -0,0 +1,278 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// LabelSetting represents a project label. Labels are matched by name, ignoring
+// case, and renamed to match the config when the case differs.
+type LabelSetting struct {
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	Description string `json:"description,omitempty"`
+	Priority    *int   `json:"priority,omitempty"`
+}
+
+func updateLabelsSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch labels from config file and return if a nil object is returned.
+	cfgSettings, removeUnlisted := cfg.LabelsSettings(p.PathWithNamespace)
+	if cfgSettings == nil {
+		return nil
+	}
+
+	// Fetch current project labels.
+	projectSettings, err := listProjectLabels(client, p.ID)
+	if err != nil {
+		cfg.fetchFailed(p, "Labels", err)
+		return nil
+	}
+
+	// Loop through labels mentioned in config file
+	for _, cfgSetting := range cfgSettings {
+		if cfgSetting.Name == "" || cfgSetting.Color == "" {
+			return fmt.Errorf("Labels must have a name and color")
+		}
+
+		// Look for an existing label; if nothing is found we'll create it.
+		var projectSetting *gitlab.Label
+		for _, ps := range projectSettings {
+			if strings.EqualFold(ps.Name, cfgSetting.Name) {
+				projectSetting = ps
+			}
+		}
+
+		if projectSetting == nil {
+			cfg.check(p, "Labels", false, fmt.Sprintf("%s is missing", cfgSetting.Name))
+			cfg.printf("Project %s's %s Label needs creating ... ", p.PathWithNamespace, cfgSetting.Name)
+
+			if cfg.DryRun {
+				cfg.printf("skipping because this is a dry run\n")
+				continue
+			}
+
+			opts := &gitlab.CreateLabelOptions{
+				Name:        &cfgSetting.Name,
+				Color:       &cfgSetting.Color,
+				Description: &cfgSetting.Description,
+				Priority:    cfgSetting.Priority,
+			}
+
+			audit, err := cfg.snapshot(p, "label", cfgSetting.Name, false, &gitlab.Label{Name: cfgSetting.Name})
+			if err != nil {
+				return err
+			}
+
+			cfg.printf("Updating project ... ")
+
+			if _, _, err := client.Labels.CreateLabel(p.ID, opts, audit); err != nil {
+				cfg.printf("Failed to create label: %s\n", err)
+				cfg.updated(p, "Labels", err)
+				continue
+			}
+			cfg.updated(p, "Labels", nil)
+			cfg.printf("Success!\n")
+
+			continue
+		}
+
+		if projectSetting.Name == cfgSetting.Name &&
+			strings.EqualFold(projectSetting.Color, cfgSetting.Color) &&
+			projectSetting.Description == cfgSetting.Description &&
+			(cfgSetting.Priority == nil || projectSetting.Priority == *cfgSetting.Priority) {
+			cfg.check(p, "Labels", true, "")
+			cfg.printf("Project %s's %s Label doesn't need updating\n", p.PathWithNamespace, cfgSetting.Name)
+			continue
+		}
+
+		current := &LabelSetting{Name: projectSetting.Name, Color: projectSetting.Color, Description: projectSetting.Description}
+		if cfgSetting.Priority != nil {
+			current.Priority = &projectSetting.Priority
+		}
+		cfg.check(p, "Labels", false, fmt.Sprintf("%s: %s", cfgSetting.Name, diffObjects(current, cfgSetting)))
+
+		cfg.printf("Project %s's %s Label needs updating ... ", p.PathWithNamespace, cfgSetting.Name)
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			continue
+		}
+
+		opts := &gitlab.UpdateLabelOptions{
+			Name:        &projectSetting.Name,
+			Color:       &cfgSetting.Color,
+			Description: &cfgSetting.Description,
+			Priority:    cfgSetting.Priority,
+		}
+		if projectSetting.Name != cfgSetting.Name {
+			opts.NewName = &cfgSetting.Name
+		}
+
+		audit, err := cfg.snapshot(p, "label", cfgSetting.Name, true, projectSetting)
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		if _, _, err := client.Labels.UpdateLabel(p.ID, opts, audit); err != nil {
+			cfg.printf("Failed to update label: %s\n", err)
+			cfg.updated(p, "Labels", err)
+			continue
+		}
+		cfg.updated(p, "Labels", nil)
+		cfg.printf("Success!\n")
+	}
+
+	if !removeUnlisted {
+		return nil
+	}
+
+	// Remove any project labels that aren't in the config.
+	for _, ps := range projectSettings {
+		listed := false
+		for _, cfgSetting := range cfgSettings {
+			if strings.EqualFold(ps.Name, cfgSetting.Name) {
+				listed = true
+			}
+		}
+		if listed {
+			continue
+		}
+
+		cfg.check(p, "Labels", false, fmt.Sprintf("%s isn't in the config", ps.Name))
+		cfg.printf("Project %s's %s Label isn't in the config and needs removing ... ", p.PathWithNamespace, ps.Name)
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			continue
+		}
+
+		audit, err := cfg.snapshot(p, "label", ps.Name, true, ps)
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		if _, err := client.Labels.DeleteLabel(p.ID, ps.ID, nil, audit); err != nil {
+			cfg.printf("Failed to remove label: %s\n", err)
+			cfg.updated(p, "Labels", err)
+			continue
+		}
+		cfg.updated(p, "Labels", nil)
+		cfg.printf("Success!\n")
+	}
+
+	return nil
+}
+
+// restoreLabel restores a label's name, color, description and priority,
+// recreating it if it was removed, or removes it if it didn't exist. Issues and
+// merge requests aren't labelled again when a removed label is recreated.
+func restoreLabel(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.Label{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	projectSettings, err := listProjectLabels(client, s.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	var projectSetting *gitlab.Label
+	for _, ps := range projectSettings {
+		if strings.EqualFold(ps.Name, before.Name) {
+			projectSetting = ps
+		}
+	}
+
+	var priority *int
+	if before.Priority != 0 {
+		priority = &before.Priority
+	}
+
+	switch {
+	case !s.Existed && projectSetting == nil:
+		return nil
+	case !s.Existed:
+		_, err = client.Labels.DeleteLabel(s.ProjectID, projectSetting.ID, nil, audit)
+	case projectSetting == nil:
+		opts := &gitlab.CreateLabelOptions{
+			Name:        &before.Name,
+			Color:       &before.Color,
+			Description: &before.Description,
+			Priority:    priority,
+		}
+		_, _, err = client.Labels.CreateLabel(s.ProjectID, opts, audit)
+	default:
+		opts := &gitlab.UpdateLabelOptions{
+			Name:        &projectSetting.Name,
+			Color:       &before.Color,
+			Description: &before.Description,
+			Priority:    priority,
+		}
+		if projectSetting.Name != before.Name {
+			opts.NewName = &before.Name
+		}
+		_, _, err = client.Labels.UpdateLabel(s.ProjectID, opts, audit)
+	}
+
+	return err
+}
+
+// listProjectLabels returns a slice containing all labels created in a project;
+// labels inherited from groups are left out.
+func listProjectLabels(client *gitlab.Client, pid int) ([]*gitlab.Label, error) {
+	labels := []*gitlab.Label{}
+
+	includeAncestorGroups := false
+	opt := &gitlab.ListLabelsOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+			Page:    1,
+		},
+		IncludeAncestorGroups: &includeAncestorGroups,
+	}
+
+	for {
+		ls, resp, err := client.Labels.ListLabels(pid, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, l := range ls {
+			if l.IsProjectLabel {
+				labels = append(labels, l)
+			}
+		}
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		opt.Page = resp.NextPage
+	}
+
+	return labels, nil
+}
@@ -0,0 +1,100 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpdateLabelsSettings(t *testing.T) {
+	// Labels inherited from a group aren't the project's to change.
+	current := `[
+		{"id": 1, "name": "bug", "color": "#FF0000", "is_project_label": true},
+		{"id": 2, "name": "Feature", "color": "#00FF00", "is_project_label": true},
+		{"id": 3, "name": "security", "color": "#000000", "is_project_label": false},
+		{"id": 4, "name": "stale", "color": "#CCCCCC", "is_project_label": true}
+	]`
+
+	tests := []struct {
+		name           string
+		removeUnlisted bool
+		dryRun         bool
+		wantWrites     []string
+		wantChecks     []string
+	}{
+		{
+			name:       "additive",
+			wantWrites: []string{"PUT /projects/1/labels", "POST /projects/1/labels"},
+			wantChecks: []string{"pass", "feature: name: Feature -> feature", "docs is missing"},
+		},
+		{
+			name:           "remove unlisted",
+			removeUnlisted: true,
+			wantWrites:     []string{"PUT /projects/1/labels", "POST /projects/1/labels", "DELETE /projects/1/labels/4"},
+			wantChecks:     []string{"pass", "feature: name: Feature -> feature", "docs is missing", "stale isn't in the config"},
+		},
+		{
+			name:           "dry run",
+			removeUnlisted: true,
+			dryRun:         true,
+			wantWrites:     []string{},
+			wantChecks:     []string{"pass", "feature: name: Feature -> feature", "docs is missing", "stale isn't in the config"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client, cfg := newFakeGitLab(t, map[string]string{
+				"GET /projects/1/labels":      current,
+				"POST /projects/1/labels":     `{"id": 5, "name": "docs"}`,
+				"PUT /projects/1/labels":      `{"id": 2, "name": "feature"}`,
+				"DELETE /projects/1/labels/4": ``,
+			})
+			cfg.DryRun = tt.dryRun
+			cfg.Groups[0].Issues.Labels = []*LabelSetting{
+				{Name: "bug", Color: "#ff0000"},
+				{Name: "feature", Color: "#00FF00"},
+				{Name: "docs", Color: "#0000FF"},
+			}
+			cfg.Groups[0].Issues.RemoveUnlistedLabels = tt.removeUnlisted
+
+			if err := updateLabelsSettings(client, testProject, cfg); err != nil {
+				t.Fatalf("updateLabelsSettings() error = %v", err)
+			}
+
+			if got := f.writes(); !reflect.DeepEqual(got, tt.wantWrites) {
+				t.Errorf("writes = %v, want %v", got, tt.wantWrites)
+			}
+
+			checks := []string{}
+			for _, c := range cfg.checks {
+				if c.Compliant {
+					checks = append(checks, "pass")
+					continue
+				}
+				checks = append(checks, c.Details)
+				if c.Updated == tt.dryRun {
+					t.Errorf("check %+v has updated %t in a dry run %t", c, c.Updated, tt.dryRun)
+				}
+			}
+			if !reflect.DeepEqual(checks, tt.wantChecks) {
+				t.Errorf("checks = %q, want %q", checks, tt.wantChecks)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// LifecycleSettings represents housekeeping rules applied to projects after
+// their settings are updated.
+type LifecycleSettings struct {
+	ArchiveAfterDays int             `json:"archive_after_days,omitempty"`
+	Renames          []*RenameRule   `json:"renames,omitempty"`
+	Transfers        []*TransferRule `json:"transfers,omitempty"`
+}
+
+// RenameRule renames projects whose path matches a regular expression; the
+// replacement may refer to capture groups, e.g. "${1}".
+type RenameRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// TransferRule transfers projects whose full path matches a regular expression
+// into another namespace.
+type TransferRule struct {
+	Pattern   string `json:"pattern"`
+	Namespace string `json:"namespace"`
+}
+
+func updateLifecycleSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch lifecycle rules from config file and return if a nil object is returned.
+	cfgSettings := cfg.LifecycleSettings(p.PathWithNamespace)
+	if cfgSettings == nil || compareObjects(cfgSettings, &LifecycleSettings{}) {
+		return nil
+	}
+
+	if err := renameProject(client, p, cfg, cfgSettings.Renames); err != nil {
+		return err
+	}
+	if err := transferProject(client, p, cfg, cfgSettings.Transfers); err != nil {
+		return err
+	}
+
+	// Archive last, as an archived project can no longer be changed.
+	return archiveProject(client, p, cfg, cfgSettings.ArchiveAfterDays)
+}
+
+// renameProject renames a project using the first rule matching it's path.
+func renameProject(client *gitlab.Client, p *gitlab.Project, cfg *Config, rules []*RenameRule) error {
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid lifecycle rename pattern %s: %s", rule.Pattern, err)
+		}
+		if !re.MatchString(p.Path) {
+			continue
+		}
+
+		newPath := re.ReplaceAllString(p.Path, rule.Replacement)
+		newName := re.ReplaceAllString(p.Name, rule.Replacement)
+		if newPath == p.Path && newName == p.Name {
+			cfg.check(p, "Lifecycle", true, "")
+			return nil
+		}
+
+		cfg.check(p, "Lifecycle", false, fmt.Sprintf("path: %s -> %s", p.Path, newPath))
+
+		cfg.printf("Project %s needs renaming to %s ... ", p.PathWithNamespace, newPath)
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			return nil
+		}
+
+		audit, err := cfg.snapshot(p, "lifecycle_rename", "", true, &gitlab.Project{Name: p.Name, Path: p.Path})
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		project, _, err := client.Projects.EditProject(p.ID, &gitlab.EditProjectOptions{Name: &newName, Path: &newPath}, audit)
+		if err != nil {
+			cfg.printf("Failed to rename project: %s\n", err)
+			cfg.updated(p, "Lifecycle", err)
+			return nil
+		}
+		cfg.updated(p, "Lifecycle", nil)
+		cfg.printf("Success!\n")
+
+		p.Name = project.Name
+		p.Path = project.Path
+		p.PathWithNamespace = project.PathWithNamespace
+
+		return nil
+	}
+
+	return nil
+}
+
+// transferProject transfers a project into the namespace of the first rule
+// matching it's full path.
+func transferProject(client *gitlab.Client, p *gitlab.Project, cfg *Config, rules []*TransferRule) error {
+	for _, rule := range rules {
+		if rule.Namespace == "" {
+			return fmt.Errorf("Lifecycle transfers must have a namespace")
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid lifecycle transfer pattern %s: %s", rule.Pattern, err)
+		}
+		if !re.MatchString(p.PathWithNamespace) {
+			continue
+		}
+
+		if strings.EqualFold(p.Namespace.FullPath, rule.Namespace) {
+			cfg.check(p, "Lifecycle", true, "")
+			return nil
+		}
+
+		cfg.check(p, "Lifecycle", false, fmt.Sprintf("namespace: %s -> %s", p.Namespace.FullPath, rule.Namespace))
+
+		cfg.printf("Project %s needs transferring to %s ... ", p.PathWithNamespace, rule.Namespace)
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			return nil
+		}
+
+		audit, err := cfg.snapshot(p, "lifecycle_transfer", "", true, p.Namespace)
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		project, _, err := client.Projects.TransferProject(p.ID, &gitlab.TransferProjectOptions{Namespace: rule.Namespace}, audit)
+		if err != nil {
+			cfg.printf("Failed to transfer project: %s\n", err)
+			cfg.updated(p, "Lifecycle", err)
+			return nil
+		}
+		cfg.updated(p, "Lifecycle", nil)
+		cfg.printf("Success!\n")
+
+		p.PathWithNamespace = project.PathWithNamespace
+		p.Namespace = project.Namespace
+
+		return nil
+	}
+
+	return nil
+}
+
+// archiveProject archives a project without activity in the given number of
+// days.
+func archiveProject(client *gitlab.Client, p *gitlab.Project, cfg *Config, days int) error {
+	if days <= 0 || p.Archived || p.LastActivityAt == nil {
+		return nil
+	}
+
+	inactive := int(time.Since(*p.LastActivityAt).Hours() / 24)
+	if inactive < days {
+		cfg.check(p, "Lifecycle", true, "")
+		return nil
+	}
+
+	cfg.check(p, "Lifecycle", false, fmt.Sprintf("no activity in %d days", inactive))
+
+	cfg.printf("Project %s has had no activity in %d days and needs archiving ... ", p.PathWithNamespace, inactive)
+
+	if cfg.DryRun {
+		cfg.printf("skipping because this is a dry run\n")
+		return nil
+	}
+
+	audit, err := cfg.snapshot(p, "lifecycle_archive", "", false, nil)
+	if err != nil {
+		return err
+	}
+
+	cfg.printf("Updating project ... ")
+
+	if _, _, err := client.Projects.ArchiveProject(p.ID, audit); err != nil {
+		cfg.printf("Failed to archive project: %s\n", err)
+		cfg.updated(p, "Lifecycle", err)
+		return nil
+	}
+	cfg.updated(p, "Lifecycle", nil)
+	cfg.printf("Success!\n")
+
+	p.Archived = true
+
+	return nil
+}
+
+// restoreRename gives a project the name and path it had before it was renamed.
+func restoreRename(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.Project{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	_, _, err := client.Projects.EditProject(s.ProjectID, &gitlab.EditProjectOptions{Name: &before.Name, Path: &before.Path}, audit)
+
+	return err
+}
+
+// restoreTransfer moves a project back into the namespace it was transferred
+// from.
+func restoreTransfer(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.ProjectNamespace{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	_, _, err := client.Projects.TransferProject(s.ProjectID, &gitlab.TransferProjectOptions{Namespace: before.ID}, audit)
+
+	return err
+}
+
+// restoreArchive unarchives a project archived during a run.
+func restoreArchive(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	_, _, err := client.Projects.UnarchiveProject(s.ProjectID, audit)
+
+	return err
+}
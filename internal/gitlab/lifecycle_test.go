@@ -0,0 +1,197 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestUpdateLifecycleSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		dryRun      bool
+		statuses    map[string]int
+		wantWrites  []string
+		wantDetails []string
+		wantPath    string
+		wantUpdated bool
+		wantFailed  bool
+	}{
+		{
+			name:       "dry run",
+			dryRun:     true,
+			wantWrites: []string{},
+			wantDetails: []string{
+				"path: legacy-api -> api",
+				"namespace: acme -> acme/archive",
+				"no activity in 400 days",
+			},
+			wantPath: "acme/legacy-api",
+		},
+		{
+			name: "renamed, transferred and archived",
+			wantWrites: []string{
+				"PUT /projects/1",
+				"PUT /projects/1/transfer",
+				"POST /projects/1/archive",
+			},
+			wantDetails: []string{
+				"path: legacy-api -> api",
+				"namespace: acme -> acme/archive",
+				"no activity in 400 days",
+			},
+			wantPath:    "acme/archive/api",
+			wantUpdated: true,
+		},
+		{
+			name:     "failed to archive",
+			statuses: map[string]int{"POST /projects/1/archive": http.StatusForbidden},
+			wantWrites: []string{
+				"PUT /projects/1",
+				"PUT /projects/1/transfer",
+				"POST /projects/1/archive",
+			},
+			wantDetails: []string{
+				"path: legacy-api -> api",
+				"namespace: acme -> acme/archive",
+				"no activity in 400 days",
+			},
+			wantPath:   "acme/archive/api",
+			wantFailed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client, cfg := newFakeGitLab(t, map[string]string{
+				"PUT /projects/1":          `{"id": 1, "name": "api", "path": "api", "path_with_namespace": "acme/api"}`,
+				"PUT /projects/1/transfer": `{"id": 1, "path_with_namespace": "acme/archive/api", "namespace": {"id": 9, "full_path": "acme/archive"}}`,
+				"POST /projects/1/archive": `{"id": 1, "archived": true}`,
+			})
+			for route, status := range tt.statuses {
+				f.statuses[route] = status
+			}
+			cfg.DryRun = tt.dryRun
+			cfg.Groups[0].Lifecycle = LifecycleSettings{
+				ArchiveAfterDays: 365,
+				Renames:          []*RenameRule{{Pattern: "^legacy-(.*)$", Replacement: "${1}"}},
+				Transfers:        []*TransferRule{{Pattern: "^acme/", Namespace: "acme/archive"}},
+			}
+
+			lastActivity := time.Now().Add(-400 * 24 * time.Hour)
+			p := &gitlab.Project{
+				ID:                1,
+				Name:              "legacy-api",
+				Path:              "legacy-api",
+				PathWithNamespace: "acme/legacy-api",
+				Namespace:         &gitlab.ProjectNamespace{ID: 2, FullPath: "acme"},
+				LastActivityAt:    &lastActivity,
+			}
+
+			if err := updateLifecycleSettings(client, p, cfg); err != nil {
+				t.Fatalf("updateLifecycleSettings() error = %v", err)
+			}
+
+			if got := f.writes(); !reflect.DeepEqual(got, tt.wantWrites) {
+				t.Errorf("writes = %v, want %v", got, tt.wantWrites)
+			}
+			// Later rules see the project as it is after the earlier ones were applied.
+			if p.PathWithNamespace != tt.wantPath {
+				t.Errorf("path = %s, want %s", p.PathWithNamespace, tt.wantPath)
+			}
+
+			var details []string
+			for _, c := range cfg.checks {
+				details = append(details, c.Details)
+			}
+			if !reflect.DeepEqual(details, tt.wantDetails) {
+				t.Errorf("details = %v, want %v", details, tt.wantDetails)
+			}
+			archive := cfg.checks[len(cfg.checks)-1]
+			if archive.Updated != tt.wantUpdated || (archive.Error != "") != tt.wantFailed {
+				t.Errorf("archive check = %+v, want updated %v and failed %v", archive, tt.wantUpdated, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestUpdateLifecycleSettingsCompliant(t *testing.T) {
+	f, client, cfg := newFakeGitLab(t, nil)
+	cfg.Groups[0].Lifecycle = LifecycleSettings{
+		ArchiveAfterDays: 365,
+		Renames:          []*RenameRule{{Pattern: "^legacy-(.*)$", Replacement: "${1}"}},
+		Transfers:        []*TransferRule{{Pattern: "^acme/", Namespace: "ACME"}},
+	}
+
+	lastActivity := time.Now().Add(-24 * time.Hour)
+	p := &gitlab.Project{
+		ID:                1,
+		Name:              "api",
+		Path:              "api",
+		PathWithNamespace: "acme/api",
+		Namespace:         &gitlab.ProjectNamespace{ID: 2, FullPath: "acme"},
+		LastActivityAt:    &lastActivity,
+	}
+
+	if err := updateLifecycleSettings(client, p, cfg); err != nil {
+		t.Fatalf("updateLifecycleSettings() error = %v", err)
+	}
+
+	if len(f.requests) > 0 {
+		t.Errorf("requests = %v, want none", f.requests)
+	}
+	// The rename rule doesn't match, so only the transfer and archive rules are checked.
+	if len(cfg.checks) != 2 {
+		t.Fatalf("checks = %+v, want 2", cfg.checks)
+	}
+	for _, c := range cfg.checks {
+		if !c.Compliant {
+			t.Errorf("check = %+v, want it to be compliant", c)
+		}
+	}
+}
+
+func TestUpdateLifecycleSettingsValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		lifecycle LifecycleSettings
+	}{
+		{name: "invalid rename pattern", lifecycle: LifecycleSettings{Renames: []*RenameRule{{Pattern: "("}}}},
+		{name: "invalid transfer pattern", lifecycle: LifecycleSettings{Transfers: []*TransferRule{{Pattern: "(", Namespace: "acme/archive"}}}},
+		{name: "transfer without namespace", lifecycle: LifecycleSettings{Transfers: []*TransferRule{{Pattern: ".*"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client, cfg := newFakeGitLab(t, nil)
+			cfg.Groups[0].Lifecycle = tt.lifecycle
+
+			p := &gitlab.Project{ID: 1, Path: "api", PathWithNamespace: "acme/api", Namespace: &gitlab.ProjectNamespace{FullPath: "acme"}}
+			if err := updateLifecycleSettings(client, p, cfg); err == nil {
+				t.Error("updateLifecycleSettings() error = nil, want an error")
+			}
+			if len(f.requests) > 0 {
+				t.Errorf("requests = %v, want none for an invalid config", f.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// defaultFilesBranch is the branch managed files are committed to when no branch
+// is configured.
+const defaultFilesBranch = "repo-settings/files"
+
+// FileSetting represents a repository file managed by the config, such as
+// CODEOWNERS. Source is a local file and Target the path in the repository.
+type FileSetting struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+func updateFilesSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch managed files from config file and return if a nil object is returned.
+	cfgSettings, branch := cfg.FilesSettings(p.PathWithNamespace)
+	if cfgSettings == nil {
+		return nil
+	}
+
+	files := []*repositoryFile{}
+	for _, cfgSetting := range cfgSettings {
+		if cfgSetting.Source == "" || cfgSetting.Target == "" {
+			return fmt.Errorf("Files must have a source and target")
+		}
+
+		content, err := readLocalFile(cfgSetting.Source)
+		if err != nil {
+			return err
+		}
+		files = append(files, &repositoryFile{
+			Path:    strings.TrimPrefix(cfgSetting.Target, "/"),
+			Content: content,
+		})
+	}
+
+	if branch == "" {
+		branch = defaultFilesBranch
+	}
+
+	return updateFilesViaMergeRequest(client, p, cfg, "Managed files", branch, files)
+}
@@ -0,0 +1,500 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// MembersSettings represents the direct members of a project and the groups it
+// is shared with.
+type MembersSettings struct {
+	Users          []*MemberSetting `json:"users,omitempty"`
+	Groups         []*MemberSetting `json:"groups,omitempty"`
+	RemoveUnlisted bool             `json:"remove_unlisted,omitempty"`
+}
+
+// MemberSetting represents a user or group with access to a project. Name is a
+// username for users and a full path for groups, and ExpiresAt is a date in the
+// YYYY-MM-DD format.
+type MemberSetting struct {
+	Name        string `json:"name"`
+	AccessLevel string `json:"access_level"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+}
+
+func updateMembersSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch members from config file and return if a nil object is returned.
+	cfgSettings := cfg.MembersSettings(p.PathWithNamespace)
+	if cfgSettings == nil || compareObjects(cfgSettings, &MembersSettings{}) {
+		return nil
+	}
+	for _, m := range append(cfgSettings.Users, cfgSettings.Groups...) {
+		if err := m.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := updateUserMembers(client, p, cfg, cfgSettings); err != nil {
+		return err
+	}
+
+	return updateGroupMembers(client, p, cfg, cfgSettings)
+}
+
+// updateUserMembers ensures listed users are direct members of a project with
+// the configured access level and expiry, optionally removing unlisted members.
+func updateUserMembers(client *gitlab.Client, p *gitlab.Project, cfg *Config, cfgSettings *MembersSettings) error {
+	if cfgSettings.Users == nil && !cfgSettings.RemoveUnlisted {
+		return nil
+	}
+
+	// Fetch current direct project members.
+	projectSettings, err := listProjectMembers(client, p.ID)
+	if err != nil {
+		cfg.fetchFailed(p, "Members", err)
+		return nil
+	}
+
+	// Loop through users mentioned in config file
+	for _, cfgSetting := range cfgSettings.Users {
+		accessLevel, _ := memberAccessLevel(cfgSetting.AccessLevel)
+
+		// Look for an existing member; if nothing is found we'll add it.
+		var projectSetting *gitlab.ProjectMember
+		for _, ps := range projectSettings {
+			if strings.EqualFold(ps.Username, cfgSetting.Name) {
+				projectSetting = ps
+			}
+		}
+
+		if projectSetting == nil {
+			cfg.check(p, "Members", false, fmt.Sprintf("%s is missing", cfgSetting.Name))
+			cfg.printf("Project %s's %s Member needs adding ... ", p.PathWithNamespace, cfgSetting.Name)
+
+			if cfg.DryRun {
+				cfg.printf("skipping because this is a dry run\n")
+				continue
+			}
+
+			user, err := findUser(client, cfgSetting.Name)
+			if err != nil {
+				cfg.printf("Failed to find user: %s\n", err)
+				cfg.updated(p, "Members", err)
+				continue
+			}
+
+			opts := &gitlab.AddProjectMemberOptions{
+				UserID:      user.ID,
+				AccessLevel: &accessLevel,
+			}
+			if cfgSetting.ExpiresAt != "" {
+				opts.ExpiresAt = &cfgSetting.ExpiresAt
+			}
+			audit, err := cfg.snapshot(p, "member", cfgSetting.Name, false, &gitlab.ProjectMember{ID: user.ID, Username: user.Username})
+			if err != nil {
+				return err
+			}
+
+			cfg.printf("Updating project ... ")
+
+			if _, _, err := client.ProjectMembers.AddProjectMember(p.ID, opts, audit); err != nil {
+				cfg.printf("Failed to add member: %s\n", err)
+				cfg.updated(p, "Members", err)
+				continue
+			}
+			cfg.updated(p, "Members", nil)
+			cfg.printf("Success!\n")
+
+			continue
+		}
+
+		// Members without an expiry in the config keep their current expiry, as
+		// GitLab's API can't remove one.
+		if projectSetting.AccessLevel == accessLevel &&
+			(cfgSetting.ExpiresAt == "" || memberExpiry(projectSetting.ExpiresAt) == cfgSetting.ExpiresAt) {
+			cfg.check(p, "Members", true, "")
+			cfg.printf("Project %s's %s Member doesn't need updating\n", p.PathWithNamespace, cfgSetting.Name)
+			continue
+		}
+
+		changes := []string{}
+		if projectSetting.AccessLevel != accessLevel {
+			changes = append(changes, fmt.Sprintf("access_level: %d -> %d", projectSetting.AccessLevel, accessLevel))
+		}
+		if cfgSetting.ExpiresAt != "" && memberExpiry(projectSetting.ExpiresAt) != cfgSetting.ExpiresAt {
+			changes = append(changes, fmt.Sprintf("expires_at: %s -> %s", memberExpiry(projectSetting.ExpiresAt), cfgSetting.ExpiresAt))
+		}
+		cfg.check(p, "Members", false, fmt.Sprintf("%s: %s", cfgSetting.Name, strings.Join(changes, ", ")))
+
+		cfg.printf("Project %s's %s Member needs updating ... ", p.PathWithNamespace, cfgSetting.Name)
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			continue
+		}
+
+		opts := &gitlab.EditProjectMemberOptions{
+			AccessLevel: &accessLevel,
+		}
+		if cfgSetting.ExpiresAt != "" {
+			opts.ExpiresAt = &cfgSetting.ExpiresAt
+		}
+		audit, err := cfg.snapshot(p, "member", cfgSetting.Name, true, projectSetting)
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		if _, _, err := client.ProjectMembers.EditProjectMember(p.ID, projectSetting.ID, opts, audit); err != nil {
+			cfg.printf("Failed to update member: %s\n", err)
+			cfg.updated(p, "Members", err)
+			continue
+		}
+		cfg.updated(p, "Members", nil)
+		cfg.printf("Success!\n")
+	}
+
+	if !cfgSettings.RemoveUnlisted {
+		return nil
+	}
+
+	// Never remove the user we're running as, as that would lock us out.
+	currentUser, _, err := client.Users.CurrentUser()
+	if err != nil {
+		cfg.fetchFailed(p, "Members", err)
+		return nil
+	}
+
+	// Remove any direct project members that aren't in the config.
+	for _, ps := range projectSettings {
+		if ps.ID == currentUser.ID {
+			continue
+		}
+
+		listed := false
+		for _, cfgSetting := range cfgSettings.Users {
+			if strings.EqualFold(ps.Username, cfgSetting.Name) {
+				listed = true
+			}
+		}
+		if listed {
+			continue
+		}
+
+		cfg.check(p, "Members", false, fmt.Sprintf("%s isn't in the config", ps.Username))
+		cfg.printf("Project %s's %s Member isn't in the config and needs removing ... ", p.PathWithNamespace, ps.Username)
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			continue
+		}
+
+		audit, err := cfg.snapshot(p, "member", ps.Username, true, ps)
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		if _, err := client.ProjectMembers.DeleteProjectMember(p.ID, ps.ID, audit); err != nil {
+			cfg.printf("Failed to remove member: %s\n", err)
+			cfg.updated(p, "Members", err)
+			continue
+		}
+		cfg.updated(p, "Members", nil)
+		cfg.printf("Success!\n")
+	}
+
+	return nil
+}
+
+// updateGroupMembers ensures a project is shared with the listed groups using
+// the configured access level.
+func updateGroupMembers(client *gitlab.Client, p *gitlab.Project, cfg *Config, cfgSettings *MembersSettings) error {
+	if cfgSettings.Groups == nil {
+		return nil
+	}
+
+	// Fetch the groups the project is currently shared with.
+	project, _, err := client.Projects.GetProject(p.ID, nil)
+	if err != nil {
+		cfg.fetchFailed(p, "Members", err)
+		return nil
+	}
+
+	// Loop through groups mentioned in config file
+	for _, cfgSetting := range cfgSettings.Groups {
+		accessLevel, _ := memberAccessLevel(cfgSetting.AccessLevel)
+
+		groupID := 0
+		currentAccessLevel := gitlab.NoPermissions
+		for _, g := range project.SharedWithGroups {
+			if strings.EqualFold(g.GroupFullPath, cfgSetting.Name) {
+				groupID = g.GroupID
+				currentAccessLevel = gitlab.AccessLevelValue(g.GroupAccessLevel)
+			}
+		}
+
+		// GitLab doesn't return the expiry of a share, so only the access level is
+		// compared.
+		if groupID != 0 && currentAccessLevel == accessLevel {
+			cfg.check(p, "Members", true, "")
+			cfg.printf("Project %s's %s Group Member doesn't need updating\n", p.PathWithNamespace, cfgSetting.Name)
+			continue
+		}
+
+		action := "adding"
+		if groupID != 0 {
+			action = "updating"
+			cfg.check(p, "Members", false, fmt.Sprintf("%s: access_level: %d -> %d", cfgSetting.Name, currentAccessLevel, accessLevel))
+		} else {
+			cfg.check(p, "Members", false, fmt.Sprintf("%s is missing", cfgSetting.Name))
+		}
+		cfg.printf("Project %s's %s Group Member needs %s ... ", p.PathWithNamespace, cfgSetting.Name, action)
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			continue
+		}
+
+		before := &groupShare{GroupID: groupID, GroupFullPath: cfgSetting.Name, GroupAccessLevel: int(currentAccessLevel)}
+		audit, err := cfg.snapshot(p, "group_share", cfgSetting.Name, groupID != 0, before)
+		if err != nil {
+			return err
+		}
+
+		// A share can't be edited, so it's removed and added again with the new
+		// access level.
+		if groupID != 0 {
+			if _, err := client.Projects.DeleteSharedProjectFromGroup(p.ID, groupID, audit); err != nil {
+				cfg.printf("Failed to remove group share: %s\n", err)
+				cfg.updated(p, "Members", err)
+				continue
+			}
+		} else {
+			group, _, err := client.Groups.GetGroup(cfgSetting.Name, nil)
+			if err != nil {
+				cfg.printf("Failed to find group: %s\n", err)
+				cfg.updated(p, "Members", err)
+				continue
+			}
+			groupID = group.ID
+		}
+
+		opts := &gitlab.ShareWithGroupOptions{
+			GroupID:     &groupID,
+			GroupAccess: &accessLevel,
+		}
+		if cfgSetting.ExpiresAt != "" {
+			opts.ExpiresAt = &cfgSetting.ExpiresAt
+		}
+
+		cfg.printf("Updating project ... ")
+
+		if _, err := client.Projects.ShareProjectWithGroup(p.ID, opts, audit); err != nil {
+			cfg.printf("Failed to share project with group: %s\n", err)
+			cfg.updated(p, "Members", err)
+			continue
+		}
+		cfg.updated(p, "Members", nil)
+		cfg.printf("Success!\n")
+	}
+
+	return nil
+}
+
+// groupShare represents a group a project is shared with.
+type groupShare struct {
+	GroupID          int    `json:"group_id"`
+	GroupFullPath    string `json:"group_full_path"`
+	GroupAccessLevel int    `json:"group_access_level"`
+}
+
+// restoreMember restores a member's access level and expiry, adding them again
+// if they were removed, or removes them if they weren't a member. An expiry
+// added during a run can't be removed, as GitLab's API doesn't support it.
+func restoreMember(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.ProjectMember{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	projectSettings, err := listProjectMembers(client, s.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	var projectSetting *gitlab.ProjectMember
+	for _, ps := range projectSettings {
+		if ps.ID == before.ID {
+			projectSetting = ps
+		}
+	}
+
+	var expiresAt *string
+	if before.ExpiresAt != nil {
+		e := memberExpiry(before.ExpiresAt)
+		expiresAt = &e
+	}
+
+	switch {
+	case !s.Existed && projectSetting == nil:
+		return nil
+	case !s.Existed:
+		_, err = client.ProjectMembers.DeleteProjectMember(s.ProjectID, before.ID, audit)
+	case projectSetting == nil:
+		opts := &gitlab.AddProjectMemberOptions{UserID: before.ID, AccessLevel: &before.AccessLevel, ExpiresAt: expiresAt}
+		_, _, err = client.ProjectMembers.AddProjectMember(s.ProjectID, opts, audit)
+	default:
+		opts := &gitlab.EditProjectMemberOptions{AccessLevel: &before.AccessLevel, ExpiresAt: expiresAt}
+		_, _, err = client.ProjectMembers.EditProjectMember(s.ProjectID, before.ID, opts, audit)
+	}
+
+	return err
+}
+
+// restoreGroupShare shares a project with a group using the access level it had
+// before, or stops sharing it if it wasn't shared. GitLab doesn't return the
+// expiry of a share, so a restored share doesn't expire.
+func restoreGroupShare(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &groupShare{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	if before.GroupID == 0 {
+		group, _, err := client.Groups.GetGroup(before.GroupFullPath, nil)
+		if err != nil {
+			return err
+		}
+		before.GroupID = group.ID
+	}
+
+	resp, err := client.Projects.DeleteSharedProjectFromGroup(s.ProjectID, before.GroupID, audit)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return err
+	}
+	if !s.Existed {
+		return nil
+	}
+
+	accessLevel := gitlab.AccessLevelValue(before.GroupAccessLevel)
+	opts := &gitlab.ShareWithGroupOptions{
+		GroupID:     &before.GroupID,
+		GroupAccess: &accessLevel,
+	}
+	_, err = client.Projects.ShareProjectWithGroup(s.ProjectID, opts, audit)
+
+	return err
+}
+
+// validate checks a member has a name, a supported access level and a valid
+// expiry date.
+func (m *MemberSetting) validate() error {
+	if m.Name == "" {
+		return fmt.Errorf("Members must have a name")
+	}
+	if _, err := memberAccessLevel(m.AccessLevel); err != nil {
+		return err
+	}
+	if m.ExpiresAt != "" {
+		if _, err := time.Parse("2006-01-02", m.ExpiresAt); err != nil {
+			return fmt.Errorf("Invalid member expires_at: %s", m.ExpiresAt)
+		}
+	}
+
+	return nil
+}
+
+// memberAccessLevel converts the name of a role into it's access level.
+func memberAccessLevel(s string) (gitlab.AccessLevelValue, error) {
+	switch strings.ToLower(s) {
+	case "guest":
+		return gitlab.GuestPermissions, nil
+	case "reporter":
+		return gitlab.ReporterPermissions, nil
+	case "developer":
+		return gitlab.DeveloperPermissions, nil
+	case "maintainer":
+		return gitlab.MaintainerPermissions, nil
+	case "owner":
+		return gitlab.OwnerPermissions, nil
+	}
+
+	return gitlab.NoPermissions, fmt.Errorf("Invalid member access level: %s", s)
+}
+
+// memberExpiry returns a member's expiry date in the YYYY-MM-DD format, or an
+// empty string if it doesn't expire.
+func memberExpiry(t *gitlab.ISOTime) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.String()
+}
+
+// findUser returns the user with the given username.
+func findUser(client *gitlab.Client, username string) (*gitlab.User, error) {
+	users, _, err := client.Users.ListUsers(&gitlab.ListUsersOptions{Username: &username})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("User %s doesn't exist", username)
+	}
+
+	return users[0], nil
+}
+
+// listProjectMembers returns a slice containing the direct members of a project;
+// members inherited from groups are left out.
+func listProjectMembers(client *gitlab.Client, pid int) ([]*gitlab.ProjectMember, error) {
+	members := []*gitlab.ProjectMember{}
+
+	opt := &gitlab.ListProjectMembersOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+			Page:    1,
+		},
+	}
+
+	for {
+		ms, resp, err := client.ProjectMembers.ListProjectMembers(pid, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, ms...)
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		opt.Page = resp.NextPage
+	}
+
+	return members, nil
+}
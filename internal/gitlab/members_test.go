@@ -0,0 +1,125 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpdateMembersSettings(t *testing.T) {
+	f, client, cfg := newFakeGitLab(t, map[string]string{
+		"GET /projects/1/members": `[
+			{"id": 1, "username": "bot", "access_level": 40},
+			{"id": 2, "username": "alice", "access_level": 30},
+			{"id": 3, "username": "bob", "access_level": 30},
+			{"id": 4, "username": "mallory", "access_level": 40}
+		]`,
+		"GET /users":                   `[{"id": 5, "username": "carol"}]`,
+		"GET /user":                    `{"id": 1, "username": "bot"}`,
+		"POST /projects/1/members":     `{"id": 5}`,
+		"PUT /projects/1/members/3":    `{"id": 3}`,
+		"DELETE /projects/1/members/4": ``,
+		"GET /projects/1": `{"id": 1, "shared_with_groups": [
+			{"group_id": 7, "group_full_path": "acme/devs", "group_access_level": 30},
+			{"group_id": 8, "group_full_path": "acme/ops", "group_access_level": 30}
+		]}`,
+		"DELETE /projects/1/share/8": ``,
+		"POST /projects/1/share":     ``,
+	})
+	cfg.Groups[0].Members = MembersSettings{
+		Users: []*MemberSetting{
+			{Name: "Alice", AccessLevel: "developer"},
+			{Name: "bob", AccessLevel: "maintainer"},
+			{Name: "carol", AccessLevel: "reporter"},
+		},
+		Groups: []*MemberSetting{
+			{Name: "acme/devs", AccessLevel: "developer"},
+			{Name: "acme/ops", AccessLevel: "maintainer"},
+			// The stub doesn't know this group, so it can't be found.
+			{Name: "acme/qa", AccessLevel: "reporter"},
+		},
+		RemoveUnlisted: true,
+	}
+
+	if err := updateMembersSettings(client, testProject, cfg); err != nil {
+		t.Fatalf("updateMembersSettings() error = %v", err)
+	}
+
+	// The user we're running as is never removed.
+	wantWrites := []string{
+		"PUT /projects/1/members/3",
+		"POST /projects/1/members",
+		"DELETE /projects/1/members/4",
+		"DELETE /projects/1/share/8",
+		"POST /projects/1/share",
+	}
+	if got := f.writes(); !reflect.DeepEqual(got, wantWrites) {
+		t.Errorf("writes = %v, want %v", got, wantWrites)
+	}
+
+	type result struct {
+		details string
+		updated bool
+		failed  bool
+	}
+	got := []result{}
+	for _, c := range cfg.checks {
+		details := c.Details
+		if c.Compliant {
+			details = "pass"
+		}
+		got = append(got, result{details, c.Updated, c.Error != ""})
+	}
+	want := []result{
+		{"pass", false, false},
+		{"bob: access_level: 30 -> 40", true, false},
+		{"carol is missing", true, false},
+		{"mallory isn't in the config", true, false},
+		{"pass", false, false},
+		{"acme/ops: access_level: 30 -> 40", true, false},
+		{"acme/qa is missing", false, true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checks = %+v, want %+v", got, want)
+	}
+}
+
+func TestUpdateMembersSettingsValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		member *MemberSetting
+	}{
+		{name: "missing name", member: &MemberSetting{AccessLevel: "developer"}},
+		{name: "invalid access level", member: &MemberSetting{Name: "alice", AccessLevel: "admin"}},
+		{name: "invalid expiry", member: &MemberSetting{Name: "alice", AccessLevel: "developer", ExpiresAt: "next week"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client, cfg := newFakeGitLab(t, nil)
+			cfg.Groups[0].Members.Users = []*MemberSetting{tt.member}
+
+			if err := updateMembersSettings(client, testProject, cfg); err == nil {
+				t.Error("updateMembersSettings() error = nil, want an error")
+			}
+			if len(f.requests) > 0 {
+				t.Errorf("requests = %v, want none for an invalid config", f.requests)
+			}
+		})
+	}
+}
@@ -18,30 +18,41 @@ package gitlab
 
 import (
 	"encoding/json"
-	"fmt"
 
 	"github.com/imdario/mergo"
 	"github.com/jinzhu/copier"
 	"github.com/xanzy/go-gitlab"
 )
 
+// MergeRequestApprovalSettings represents a project's Merge Request Approval
+// settings.
+type MergeRequestApprovalSettings struct {
+	Mode                    string `json:"mode,omitempty"`
+	gitlab.ProjectApprovals `mapstructure:",squash"`
+}
+
 func updateMergeRequestAppovalsSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
 	// Fetch Merge Request Approval settings from config file and return if a nil object is returned.
-	cfgSettings := cfg.MergeRequestApprovalSettings(p.Namespace.FullPath)
-	if compareObjects(cfgSettings, &gitlab.ProjectApprovals{}) {
+	cfgSettings := cfg.MergeRequestApprovalSettings(p.PathWithNamespace)
+	if cfgSettings == nil || compareObjects(cfgSettings, &MergeRequestApprovalSettings{}) {
 		return nil
 	}
+	exact, err := exactMode(cfgSettings.Mode)
+	if err != nil {
+		return err
+	}
 
 	// Fetch current Merge Request Approval settings.
 	projectSettings, _, err := client.Projects.GetApprovalConfiguration(p.ID)
 	if err != nil {
+		cfg.fetchFailed(p, "Merge Request Approvals", err)
 		return nil
 	}
 
 	// Populate newSettings with cfgSettings values, we do this otherwise mergo.Merge wigs out and raises
 	// an exception.
 	newSettings := &gitlab.ProjectApprovals{}
-	if err := copier.Copy(&newSettings, &cfgSettings); err != nil {
+	if err := copier.Copy(&newSettings, &cfgSettings.ProjectApprovals); err != nil {
 		return err
 	}
 
@@ -54,17 +65,31 @@ func updateMergeRequestAppovalsSettings(client *gitlab.Client, p *gitlab.Project
 		return err
 	}
 
+	// In exact mode the config is the full desired state, so any option not enabled in
+	// the config is switched off.
+	if exact {
+		newSettings.ResetApprovalsOnPush = cfgSettings.ResetApprovalsOnPush
+		newSettings.DisableOverridingApproversPerMergeRequest = cfgSettings.DisableOverridingApproversPerMergeRequest
+		newSettings.MergeRequestsAuthorApproval = cfgSettings.MergeRequestsAuthorApproval
+		newSettings.MergeRequestsDisableCommittersApproval = cfgSettings.MergeRequestsDisableCommittersApproval
+		newSettings.RequirePasswordToApprove = cfgSettings.RequirePasswordToApprove
+		newSettings.SelectiveCodeOwnerRemovals = cfgSettings.SelectiveCodeOwnerRemovals
+	}
+
 	// Return if our proposed config matches the actual config
 	if compareObjects(projectSettings, newSettings) {
-		fmt.Printf("Project %s's Merge Request Approval settings don't need updating\n", p.PathWithNamespace)
+		cfg.check(p, "Merge Request Approvals", true, "")
+		cfg.printf("Project %s's Merge Request Approval settings don't need updating\n", p.PathWithNamespace)
 
 		return nil
 	}
 
-	fmt.Printf("Project %s's Merge Request Approval settings need updating ... ", p.PathWithNamespace)
+	cfg.check(p, "Merge Request Approvals", false, diffObjects(projectSettings, newSettings))
+
+	cfg.printf("Project %s's Merge Request Approval settings need updating ... ", p.PathWithNamespace)
 
 	if cfg.DryRun {
-		fmt.Printf("skipping because this is a dry run\n")
+		cfg.printf("skipping because this is a dry run\n")
 		return nil
 	}
 
@@ -74,14 +99,33 @@ func updateMergeRequestAppovalsSettings(client *gitlab.Client, p *gitlab.Project
 	if err := json.Unmarshal(settingsData, &opts); err != nil {
 		return err
 	}
+	audit, err := cfg.snapshot(p, "merge_request_approvals", "", true, projectSettings)
+	if err != nil {
+		return err
+	}
 
-	fmt.Printf("Updating project ... ")
+	cfg.printf("Updating project ... ")
 
-	_, _, err = client.Projects.ChangeApprovalConfiguration(p.ID, opts)
+	_, _, err = client.Projects.ChangeApprovalConfiguration(p.ID, opts, audit)
 	if err != nil {
+		cfg.updated(p, "Merge Request Approvals", err)
 		return err
 	}
-	fmt.Printf("Success!\n")
+	cfg.updated(p, "Merge Request Approvals", nil)
+	cfg.printf("Success!\n")
 
 	return nil
 }
+
+// restoreMergeRequestApprovals restores a project's Merge Request Approval
+// settings.
+func restoreMergeRequestApprovals(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	opts := &gitlab.ChangeApprovalConfigurationOptions{}
+	if err := json.Unmarshal(s.Before, opts); err != nil {
+		return err
+	}
+
+	_, _, err := client.Projects.ChangeApprovalConfiguration(s.ProjectID, opts, audit)
+
+	return err
+}
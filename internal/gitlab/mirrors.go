@@ -0,0 +1,261 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// MirrorSetting represents a push mirror. The URL is given without credentials
+// and may contain the %{project_path}, %{project_namespace} and %{project_name}
+// placeholders; the username and password are added when the mirror is created,
+// as GitLab masks them once the mirror exists.
+type MirrorSetting struct {
+	URL                   string  `json:"url"`
+	Username              string  `json:"username,omitempty"`
+	Password              *Secret `json:"password,omitempty"`
+	Enabled               *bool   `json:"enabled,omitempty"`
+	OnlyProtectedBranches *bool   `json:"only_protected_branches,omitempty"`
+	KeepDivergentRefs     *bool   `json:"keep_divergent_refs,omitempty"`
+}
+
+func updateMirrorsSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch mirrors from config file and return if a nil object is returned.
+	cfgSettings := cfg.MirrorsSettings(p.PathWithNamespace)
+	if cfgSettings == nil {
+		return nil
+	}
+
+	reportPullMirror(client, p, cfg)
+
+	// Fetch current push mirrors.
+	projectSettings, _, err := client.ProjectMirrors.ListProjectMirror(p.ID, nil)
+	if err != nil {
+		cfg.fetchFailed(p, "Push mirrors", err)
+		return nil
+	}
+
+	// Loop through mirrors mentioned in config file
+	for _, cfgSetting := range cfgSettings {
+		cfgURL, err := mirrorURL(expandProjectPlaceholders(cfgSetting.URL, p))
+		if err != nil {
+			return err
+		}
+
+		// Resolve the password first so a missing secret is also reported during a dry
+		// run, but not for a report so it can be made without access to it.
+		var password string
+		if cfgSetting.Password.IsSet() && !cfg.report {
+			if password, err = cfgSetting.Password.Resolve(); err != nil {
+				return err
+			}
+		}
+
+		// Look for an existing mirror; if nothing is found we'll create it.
+		var projectSetting *gitlab.ProjectMirror
+		for _, ps := range projectSettings {
+			if psURL, err := mirrorURL(ps.URL); err == nil && psURL == cfgURL {
+				projectSetting = ps
+			}
+		}
+
+		if projectSetting == nil {
+			cfg.check(p, "Push mirrors", false, fmt.Sprintf("%s is missing", cfgURL))
+			cfg.printf("Project %s's %s Push Mirror needs creating ... ", p.PathWithNamespace, cfgURL)
+
+			if cfg.DryRun {
+				cfg.printf("skipping because this is a dry run\n")
+				continue
+			}
+
+			u, _ := url.Parse(cfgURL)
+			switch {
+			case cfgSetting.Username != "" && password != "":
+				u.User = url.UserPassword(cfgSetting.Username, password)
+			case cfgSetting.Username != "":
+				u.User = url.User(cfgSetting.Username)
+			}
+			newURL := u.String()
+
+			opts := &gitlab.AddProjectMirrorOptions{
+				URL:                   &newURL,
+				Enabled:               cfgSetting.Enabled,
+				OnlyProtectedBranches: cfgSetting.OnlyProtectedBranches,
+				KeepDivergentRefs:     cfgSetting.KeepDivergentRefs,
+			}
+			audit, err := cfg.snapshot(p, "mirror", cfgURL, false, &gitlab.ProjectMirror{URL: cfgURL})
+			if err != nil {
+				return err
+			}
+
+			cfg.printf("Updating project ... ")
+
+			if _, _, err := client.ProjectMirrors.AddProjectMirror(p.ID, opts, audit); err != nil {
+				cfg.printf("Failed to create push mirror: %s\n", err)
+				cfg.updated(p, "Push mirrors", err)
+				continue
+			}
+			cfg.updated(p, "Push mirrors", nil)
+			cfg.printf("Success!\n")
+
+			continue
+		}
+
+		if (cfgSetting.Enabled == nil || projectSetting.Enabled == *cfgSetting.Enabled) &&
+			(cfgSetting.OnlyProtectedBranches == nil || projectSetting.OnlyProtectedBranches == *cfgSetting.OnlyProtectedBranches) &&
+			(cfgSetting.KeepDivergentRefs == nil || projectSetting.KeepDivergentRefs == *cfgSetting.KeepDivergentRefs) {
+			if cfgSetting.Password.IsSet() {
+				cfg.unverifiable(p, "Push mirrors", fmt.Sprintf("%s: password is masked by GitLab", cfgURL))
+			} else {
+				cfg.check(p, "Push mirrors", true, "")
+			}
+			cfg.printf("Project %s's %s Push Mirror doesn't need updating\n", p.PathWithNamespace, cfgURL)
+			continue
+		}
+
+		changes := []string{}
+		if cfgSetting.Enabled != nil && projectSetting.Enabled != *cfgSetting.Enabled {
+			changes = append(changes, fmt.Sprintf("enabled: %t -> %t", projectSetting.Enabled, *cfgSetting.Enabled))
+		}
+		if cfgSetting.OnlyProtectedBranches != nil && projectSetting.OnlyProtectedBranches != *cfgSetting.OnlyProtectedBranches {
+			changes = append(changes, fmt.Sprintf("only_protected_branches: %t -> %t", projectSetting.OnlyProtectedBranches, *cfgSetting.OnlyProtectedBranches))
+		}
+		if cfgSetting.KeepDivergentRefs != nil && projectSetting.KeepDivergentRefs != *cfgSetting.KeepDivergentRefs {
+			changes = append(changes, fmt.Sprintf("keep_divergent_refs: %t -> %t", projectSetting.KeepDivergentRefs, *cfgSetting.KeepDivergentRefs))
+		}
+		cfg.check(p, "Push mirrors", false, fmt.Sprintf("%s: %s", cfgURL, strings.Join(changes, ", ")))
+
+		cfg.printf("Project %s's %s Push Mirror needs updating ... ", p.PathWithNamespace, cfgURL)
+
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			continue
+		}
+
+		opts := &gitlab.EditProjectMirrorOptions{
+			Enabled:               cfgSetting.Enabled,
+			OnlyProtectedBranches: cfgSetting.OnlyProtectedBranches,
+			KeepDivergentRefs:     cfgSetting.KeepDivergentRefs,
+		}
+		audit, err := cfg.snapshot(p, "mirror", cfgURL, true, projectSetting)
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		if _, _, err := client.ProjectMirrors.EditProjectMirror(p.ID, projectSetting.ID, opts, audit); err != nil {
+			cfg.printf("Failed to update push mirror: %s\n", err)
+			cfg.updated(p, "Push mirrors", err)
+			continue
+		}
+		cfg.updated(p, "Push mirrors", nil)
+		cfg.printf("Success!\n")
+	}
+
+	return nil
+}
+
+// restoreMirror restores whether a push mirror is enabled and which branches it
+// mirrors, or removes it if it didn't exist.
+func restoreMirror(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.ProjectMirror{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	if s.Existed {
+		opts := &gitlab.EditProjectMirrorOptions{
+			Enabled:               &before.Enabled,
+			OnlyProtectedBranches: &before.OnlyProtectedBranches,
+			KeepDivergentRefs:     &before.KeepDivergentRefs,
+		}
+		_, _, err := client.ProjectMirrors.EditProjectMirror(s.ProjectID, before.ID, opts, audit)
+		return err
+	}
+
+	projectSettings, _, err := client.ProjectMirrors.ListProjectMirror(s.ProjectID, nil)
+	if err != nil {
+		return err
+	}
+	for _, ps := range projectSettings {
+		if psURL, err := mirrorURL(ps.URL); err == nil && psURL == before.URL {
+			if _, err := client.ProjectMirrors.DeleteProjectMirror(s.ProjectID, ps.ID, audit); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// reportPullMirror prints a project's pull mirror settings, if it is a pull
+// mirror. Pull mirrors are only reported and never changed; a pull mirror whose
+// last update failed is recorded as failing, as it has drifted from it's source.
+func reportPullMirror(client *gitlab.Client, p *gitlab.Project, cfg *Config) {
+	project, _, err := client.Projects.GetProject(p.ID, nil)
+	if err != nil {
+		cfg.fetchFailed(p, "Pull mirror", err)
+		return
+	}
+	if !project.Mirror {
+		return
+	}
+
+	source, err := mirrorURL(project.ImportURL)
+	if err != nil {
+		source = project.ImportURL
+	}
+
+	cfg.printf("Project %s is a Pull Mirror of %s (status: %s, only protected branches: %t, trigger pipelines: %t, overwrite diverged branches: %t)\n",
+		p.PathWithNamespace,
+		source,
+		project.ImportStatus,
+		project.OnlyMirrorProtectedBranches,
+		project.MirrorTriggerBuilds,
+		project.MirrorOverwritesDivergedBranches,
+	)
+
+	cfg.check(p, "Pull mirror", project.ImportStatus != "failed", fmt.Sprintf("%s: last update failed", source))
+}
+
+// expandProjectPlaceholders replaces project placeholders, named after the ones
+// GitLab uses for badges, with the values of a project.
+func expandProjectPlaceholders(s string, p *gitlab.Project) string {
+	return strings.NewReplacer(
+		"%{project_path}", p.PathWithNamespace,
+		"%{project_namespace}", p.Namespace.FullPath,
+		"%{project_name}", p.Path,
+	).Replace(s)
+}
+
+// mirrorURL returns a mirror URL without credentials, so mirrors can be matched
+// regardless of the (masked) credentials GitLab returns.
+func mirrorURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("Invalid mirror URL: %s", s)
+	}
+	u.User = nil
+
+	return u.String(), nil
+}
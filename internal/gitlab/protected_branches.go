@@ -17,6 +17,7 @@
 package gitlab
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -31,7 +32,7 @@ type ProtectedBranchSetting struct {
 
 func updateProtectedBranchesSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
 	// Fetch Protected Branches settings from config file and return if a nil object is returned.
-	cfgSettings := cfg.ProtectedBranchesSettings(p.Namespace.FullPath)
+	cfgSettings := cfg.ProtectedBranchesSettings(p.PathWithNamespace)
 	if cfgSettings == nil {
 		return nil
 	}
@@ -45,6 +46,7 @@ func updateProtectedBranchesSettings(client *gitlab.Client, p *gitlab.Project, c
 	}
 	projectSettings, _, err := client.ProtectedBranches.ListProtectedBranches(p.ID, listOpts)
 	if err != nil {
+		cfg.fetchFailed(p, "Protected Branches", err)
 		return nil
 	}
 
@@ -74,15 +76,22 @@ func updateProtectedBranchesSettings(client *gitlab.Client, p *gitlab.Project, c
 		}
 
 		if compareObjects(projectSetting, newSetting) {
-			fmt.Printf("Project %s's %s Branch Protection settings don't need updating\n", p.PathWithNamespace, projectSetting.Name)
+			cfg.check(p, "Protected Branches", true, "")
+			cfg.printf("Project %s's %s Branch Protection settings don't need updating\n", p.PathWithNamespace, projectSetting.Name)
 
 			return nil
 		}
 
-		fmt.Printf("Project %s's %s Branch Protection settings need updating ... ", p.PathWithNamespace, projectSetting.Name)
+		if projectSetting.Name == "" {
+			cfg.check(p, "Protected Branches", false, fmt.Sprintf("%s isn't protected", cfgSetting.Name))
+		} else {
+			cfg.check(p, "Protected Branches", false, fmt.Sprintf("%s: %s", cfgSetting.Name, diffObjects(projectSetting, newSetting)))
+		}
+
+		cfg.printf("Project %s's %s Branch Protection settings need updating ... ", p.PathWithNamespace, projectSetting.Name)
 
 		if cfg.DryRun {
-			fmt.Printf("skipping because this is a dry run\n")
+			cfg.printf("skipping because this is a dry run\n")
 			return nil
 		}
 
@@ -92,25 +101,67 @@ func updateProtectedBranchesSettings(client *gitlab.Client, p *gitlab.Project, c
 			PushAccessLevel:  &newSetting.PushAccessLevels[0].AccessLevel,
 		}
 
-		fmt.Printf("Updating project ... ")
+		var audit gitlab.RequestOptionFunc
+		if projectSetting.Name == "" {
+			audit, err = cfg.snapshot(p, "protected_branch", newSetting.Name, false, &gitlab.ProtectedBranch{Name: newSetting.Name})
+		} else {
+			audit, err = cfg.snapshot(p, "protected_branch", projectSetting.Name, true, projectSetting)
+		}
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
 
-		_, err = client.ProtectedBranches.UnprotectRepositoryBranches(p.ID, *setOpts.Name)
+		_, err = client.ProtectedBranches.UnprotectRepositoryBranches(p.ID, *setOpts.Name, audit)
 		if err != nil && err.Error() != gitlab.ErrNotFound.Error() {
-			fmt.Printf("Failed to unprotect branch: %s\n", err)
+			cfg.printf("Failed to unprotect branch: %s\n", err)
+			cfg.updated(p, "Protected Branches", err)
 			return nil
 		}
-		_, _, err = client.ProtectedBranches.ProtectRepositoryBranches(p.ID, setOpts)
+		_, _, err = client.ProtectedBranches.ProtectRepositoryBranches(p.ID, setOpts, audit)
 		if err != nil {
-			fmt.Printf("Failed to protect branch: %s\n", err)
+			cfg.printf("Failed to protect branch: %s\n", err)
+			cfg.updated(p, "Protected Branches", err)
 			return nil
 		}
-		fmt.Printf("Success!\n")
+		cfg.updated(p, "Protected Branches", nil)
+		cfg.printf("Success!\n")
 
 	}
 
 	return nil
 }
 
+// restoreProtectedBranch protects a branch with the access levels it had before,
+// or unprotects it if it wasn't protected.
+func restoreProtectedBranch(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.ProtectedBranch{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
+		return err
+	}
+
+	_, err := client.ProtectedBranches.UnprotectRepositoryBranches(s.ProjectID, before.Name, audit)
+	if err != nil && err.Error() != gitlab.ErrNotFound.Error() {
+		return err
+	}
+	if !s.Existed {
+		return nil
+	}
+
+	opts := &gitlab.ProtectRepositoryBranchesOptions{
+		Name:                      &before.Name,
+		AllowForcePush:            &before.AllowForcePush,
+		AllowedToPush:             branchPermissions(before.PushAccessLevels),
+		AllowedToMerge:            branchPermissions(before.MergeAccessLevels),
+		AllowedToUnprotect:        branchPermissions(before.UnprotectAccessLevels),
+		CodeOwnerApprovalRequired: &before.CodeOwnerApprovalRequired,
+	}
+	_, _, err = client.ProtectedBranches.ProtectRepositoryBranches(s.ProjectID, opts, audit)
+
+	return err
+}
+
 func setBranchAccess(s string) (gitlab.BranchAccessDescription, error) {
 	if strings.EqualFold(s, "developers") {
 		return gitlab.BranchAccessDescription{AccessLevel: 30, AccessLevelDescription: "Developers + Maintainers"}, nil
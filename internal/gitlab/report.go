@@ -0,0 +1,341 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// Check represents the result of comparing one of a project's settings with the
+// config. Details describe the offending values of a setting that isn't
+// compliant. A setting is unverifiable when it has values that can't be compared,
+// such as secrets GitLab never returns; Details then says which. Updated is set
+// once a setting that isn't compliant was updated, and Error holds the error of
+// an update that failed.
+type Check struct {
+	Project      string
+	Setting      string
+	Compliant    bool
+	Unverifiable bool
+	Details      string
+	Updated      bool
+	Error        string
+}
+
+// sensitiveKeys are suffixes of keys whose values are redacted from check
+// details.
+var sensitiveKeys = []string{"api_key", "password", "secret", "token", "webhook"}
+
+// check records the result of comparing one of a project's settings with the
+// config, so it can be reported on once all projects have been processed.
+func (c *Config) check(p *gitlab.Project, setting string, compliant bool, details string) {
+	c.checks = append(c.checks, &Check{
+		Project:   p.PathWithNamespace,
+		Setting:   setting,
+		Compliant: compliant,
+		Details:   details,
+	})
+}
+
+// unverifiable records that one of a project's settings matches the config as far
+// as it can be compared, but has values that can't be verified.
+func (c *Config) unverifiable(p *gitlab.Project, setting string, details string) {
+	c.checks = append(c.checks, &Check{
+		Project:      p.PathWithNamespace,
+		Setting:      setting,
+		Compliant:    true,
+		Unverifiable: true,
+		Details:      details,
+	})
+}
+
+// updated records the result of updating one of a project's settings in the check
+// last recorded for it.
+func (c *Config) updated(p *gitlab.Project, setting string, err error) {
+	for i := len(c.checks) - 1; i >= 0; i-- {
+		check := c.checks[i]
+		if check.Project != p.PathWithNamespace || check.Setting != setting {
+			continue
+		}
+
+		if err != nil {
+			check.Error = err.Error()
+		} else {
+			check.Updated = true
+		}
+		return
+	}
+}
+
+// fetchFailed records a failing check for a setting whose current state can't be
+// fetched, so it isn't mistaken for a setting that wasn't checked.
+func (c *Config) fetchFailed(p *gitlab.Project, setting string, err error) {
+	c.check(p, setting, false, fmt.Sprintf("failed to fetch current settings: %s", err))
+	c.printf("Failed to fetch project %s's %s settings: %s\n", p.PathWithNamespace, setting, err)
+}
+
+// ReportProjectsInGroups evaluates the projects in the groups defined in
+// *Config.Groups against the config without changing them, and returns the result
+// of each check.
+func ReportProjectsInGroups(cfg *Config) ([]*Check, error) {
+	cfg.DryRun = true
+	cfg.report = true
+	cfg.checks = nil
+
+	if err := UpdateProjectsInGroups(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg.checks, nil
+}
+
+// diffObjects returns the values that differ between the current and wanted
+// version of an object, as "key: current -> wanted" pairs. Nested values are
+// compared by their dotted key, and sensitive values are redacted.
+func diffObjects(current, wanted interface{}) string {
+	c := flattenObject(current)
+	w := flattenObject(wanted)
+
+	keys := map[string]interface{}{}
+	for k := range c {
+		keys[k] = nil
+	}
+	for k := range w {
+		keys[k] = nil
+	}
+
+	diffs := []string{}
+	for _, k := range sortedKeys(keys) {
+		if reflect.DeepEqual(c[k], w[k]) {
+			continue
+		}
+
+		diffs = append(diffs, diffValue(k, c[k], w[k]))
+	}
+
+	return strings.Join(diffs, ", ")
+}
+
+// diffValue describes the difference between the current and wanted value of a
+// key, redacting the values of sensitive keys.
+func diffValue(k string, current, wanted interface{}) string {
+	for _, s := range sensitiveKeys {
+		if strings.HasSuffix(strings.ToLower(k), s) {
+			return fmt.Sprintf("%s: [redacted]", k)
+		}
+	}
+
+	return fmt.Sprintf("%s: %v -> %v", k, current, wanted)
+}
+
+// flattenObject converts an object into a map of dotted keys to values, using
+// the object's JSON representation.
+func flattenObject(o interface{}) map[string]interface{} {
+	flat := map[string]interface{}{}
+
+	var data interface{}
+	b, _ := json.Marshal(o)
+	if err := json.Unmarshal(b, &data); err != nil {
+		return flat
+	}
+
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			flat[prefix] = v
+			return
+		}
+		for k, mv := range m {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			walk(k, mv)
+		}
+	}
+	walk("", data)
+
+	return flat
+}
+
+// reportMatrix groups checks into a matrix of projects by settings. A cell holds
+// "pass", "fail" with the offending values, "unverifiable" with the values that
+// can't be compared, or nothing if the setting wasn't checked for the project.
+// A cell is updated if any of it's checks were, and holds the errors of all
+// updates that failed.
+func reportMatrix(checks []*Check) ([]string, []string, map[string]map[string]*Check) {
+	projects := []string{}
+	settings := []string{}
+	cells := map[string]map[string]*Check{}
+
+	for _, c := range checks {
+		if cells[c.Project] == nil {
+			projects = append(projects, c.Project)
+			cells[c.Project] = map[string]*Check{}
+		}
+		if !contains(settings, c.Setting) {
+			settings = append(settings, c.Setting)
+		}
+
+		// Settings checked more than once, such as one check per label, are only
+		// compliant if all checks are, and only verified if all checks are.
+		cell := cells[c.Project][c.Setting]
+		if cell == nil {
+			cell = &Check{Project: c.Project, Setting: c.Setting, Compliant: true}
+			cells[c.Project][c.Setting] = cell
+		}
+		switch {
+		case !c.Compliant && cell.Compliant:
+			cell.Compliant = false
+			cell.Unverifiable = false
+			cell.Details = c.Details
+		case !c.Compliant, c.Unverifiable && cell.Compliant:
+			cell.Unverifiable = cell.Compliant
+			if cell.Details != "" {
+				cell.Details += "; "
+			}
+			cell.Details += c.Details
+		}
+
+		if c.Updated {
+			cell.Updated = true
+		}
+		if c.Error != "" {
+			if cell.Error != "" {
+				cell.Error += "; "
+			}
+			cell.Error += c.Error
+		}
+	}
+
+	return projects, settings, cells
+}
+
+// cellText returns the text of a cell in the compliance matrix.
+func cellText(c *Check) string {
+	switch {
+	case c == nil:
+		return ""
+	case c.Unverifiable:
+		return fmt.Sprintf("unverifiable: %s", c.Details)
+	case c.Compliant:
+		return "pass"
+	case c.Details == "":
+		return "fail"
+	}
+
+	return fmt.Sprintf("fail: %s", c.Details)
+}
+
+// ValidateReportFormat returns an error if a report format isn't supported.
+func ValidateReportFormat(format string) error {
+	switch strings.ToLower(format) {
+	case "csv", "html", "markdown", "md":
+		return nil
+	}
+
+	return fmt.Errorf("Unsupported report format \"%s\"; supported formats are \"csv\", \"html\" and \"markdown\"", format)
+}
+
+// WriteReport writes checks as a compliance matrix of projects by settings in
+// the given format; supported formats are "csv", "html" and "markdown".
+func WriteReport(w io.Writer, checks []*Check, format string) error {
+	projects, settings, cells := reportMatrix(checks)
+
+	switch strings.ToLower(format) {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(append([]string{"Project"}, settings...)); err != nil {
+			return err
+		}
+		for _, p := range projects {
+			row := []string{p}
+			for _, s := range settings {
+				row = append(row, cellText(cells[p][s]))
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "markdown", "md":
+		escape := strings.NewReplacer("|", "\\|", "\n", " ").Replace
+
+		header := []string{"Project"}
+		separator := []string{"---"}
+		for _, s := range settings {
+			header = append(header, escape(s))
+			separator = append(separator, "---")
+		}
+		fmt.Fprintf(w, "| %s |\n| %s |\n", strings.Join(header, " | "), strings.Join(separator, " | "))
+		for _, p := range projects {
+			row := []string{escape(p)}
+			for _, s := range settings {
+				row = append(row, escape(cellText(cells[p][s])))
+			}
+			fmt.Fprintf(w, "| %s |\n", strings.Join(row, " | "))
+		}
+		return nil
+	case "html":
+		return reportTemplate.Execute(w, map[string]interface{}{
+			"Projects": projects,
+			"Settings": settings,
+			"Cells":    cells,
+		})
+	}
+
+	return ValidateReportFormat(format)
+}
+
+// reportTemplate renders the compliance matrix as an HTML page.
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"cell": func(cells map[string]map[string]*Check, p, s string) *Check { return cells[p][s] },
+	"text": cellText,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>repo-settings compliance report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+td.pass { background: #e6f4ea; }
+td.fail { background: #fce8e6; }
+td.unverifiable { background: #fef7e0; }
+</style>
+</head>
+<body>
+<h1>repo-settings compliance report</h1>
+<table>
+<tr><th>Project</th>{{range .Settings}}<th>{{.}}</th>{{end}}</tr>
+{{- $cells := .Cells}}{{$settings := .Settings}}
+{{range $p := .Projects}}<tr><td>{{$p}}</td>{{range $s := $settings}}{{with cell $cells $p $s}}<td class="{{if .Unverifiable}}unverifiable{{else if .Compliant}}pass{{else}}fail{{end}}">{{text .}}</td>{{else}}<td></td>{{end}}{{end}}</tr>
+{{end -}}
+</table>
+</body>
+</html>
+`))
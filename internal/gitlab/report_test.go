@@ -0,0 +1,127 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import "testing"
+
+func TestReportMatrix(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []*Check
+		want   string
+	}{
+		{
+			name:   "pass",
+			checks: []*Check{{Project: "acme/api", Setting: "Labels", Compliant: true}},
+			want:   "pass",
+		},
+		{
+			name:   "fail",
+			checks: []*Check{{Project: "acme/api", Setting: "Labels", Details: "bug is missing"}},
+			want:   "fail: bug is missing",
+		},
+		{
+			name: "fail overrides pass",
+			checks: []*Check{
+				{Project: "acme/api", Setting: "Labels", Compliant: true},
+				{Project: "acme/api", Setting: "Labels", Details: "bug is missing"},
+				{Project: "acme/api", Setting: "Labels", Compliant: true},
+			},
+			want: "fail: bug is missing",
+		},
+		{
+			name: "failures are joined",
+			checks: []*Check{
+				{Project: "acme/api", Setting: "Labels", Details: "bug is missing"},
+				{Project: "acme/api", Setting: "Labels", Details: "feature is missing"},
+			},
+			want: "fail: bug is missing; feature is missing",
+		},
+		{
+			name: "unverifiable",
+			checks: []*Check{
+				{Project: "acme/api", Setting: "Jira", Compliant: true},
+				{Project: "acme/api", Setting: "Jira", Compliant: true, Unverifiable: true, Details: "password: not returned by GitLab"},
+			},
+			want: "unverifiable: password: not returned by GitLab",
+		},
+		{
+			name: "fail overrides unverifiable",
+			checks: []*Check{
+				{Project: "acme/api", Setting: "Push mirrors", Compliant: true, Unverifiable: true, Details: "password is masked by GitLab"},
+				{Project: "acme/api", Setting: "Push mirrors", Details: "https://example.com is missing"},
+			},
+			want: "fail: https://example.com is missing",
+		},
+		{
+			name: "unverifiable doesn't override fail",
+			checks: []*Check{
+				{Project: "acme/api", Setting: "Push mirrors", Details: "https://example.com is missing"},
+				{Project: "acme/api", Setting: "Push mirrors", Compliant: true, Unverifiable: true, Details: "password is masked by GitLab"},
+			},
+			want: "fail: https://example.com is missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projects, settings, cells := reportMatrix(tt.checks)
+			if len(projects) != 1 || len(settings) != 1 {
+				t.Fatalf("reportMatrix() = %v by %v, want 1 by 1", projects, settings)
+			}
+
+			if got := cellText(cells[projects[0]][settings[0]]); got != tt.want {
+				t.Errorf("cellText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportMatrixUpdates(t *testing.T) {
+	checks := []*Check{
+		{Project: "acme/api", Setting: "Labels", Details: "bug is missing", Updated: true},
+		{Project: "acme/api", Setting: "Labels", Details: "feature is missing", Error: "403 Forbidden"},
+		{Project: "acme/web", Setting: "Labels", Compliant: true},
+		{Project: "acme/web", Setting: "Members", Details: "bob is missing", Error: "404 Not Found"},
+		{Project: "acme/web", Setting: "Members", Details: "alice is missing", Error: "403 Forbidden"},
+	}
+
+	projects, settings, cells := reportMatrix(checks)
+	if len(projects) != 2 || len(settings) != 2 {
+		t.Fatalf("reportMatrix() = %v by %v, want 2 by 2", projects, settings)
+	}
+
+	tests := []struct {
+		project, setting string
+		wantUpdated      bool
+		wantError        string
+	}{
+		{"acme/api", "Labels", true, "403 Forbidden"},
+		{"acme/web", "Labels", false, ""},
+		{"acme/web", "Members", false, "404 Not Found; 403 Forbidden"},
+	}
+
+	for _, tt := range tests {
+		c := cells[tt.project][tt.setting]
+		if c.Updated != tt.wantUpdated || c.Error != tt.wantError {
+			t.Errorf("%s %s: updated = %t, error = %q, want %t, %q", tt.project, tt.setting, c.Updated, c.Error, tt.wantUpdated, tt.wantError)
+		}
+	}
+	if cells["acme/api"]["Members"] != nil {
+		t.Errorf("cell of a setting that wasn't checked = %v, want nil", cells["acme/api"]["Members"])
+	}
+}
@@ -0,0 +1,68 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Secret represents a sensitive value that is read from an environment variable,
+// a file or the output of a command, so it doesn't need to be stored in the
+// config file.
+type Secret struct {
+	Env     string `json:"env,omitempty"`
+	File    string `json:"file,omitempty"`
+	Command string `json:"command,omitempty"`
+}
+
+// IsSet returns true if a source has been configured for the secret.
+func (s *Secret) IsSet() bool {
+	return s != nil && (s.Env != "" || s.File != "" || s.Command != "")
+}
+
+// Resolve reads the secret from it's source and returns it's value. Trailing
+// newlines are removed from values read from files and commands.
+func (s *Secret) Resolve() (string, error) {
+	switch {
+	case s == nil:
+		return "", fmt.Errorf("No secret source defined")
+	case s.Env != "":
+		v, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("Cannot read secret: environment variable %s is not set", s.Env)
+		}
+		return v, nil
+	case s.File != "":
+		data, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return "", fmt.Errorf("Cannot read secret: %s", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case s.Command != "":
+		out, err := exec.Command("sh", "-c", s.Command).Output()
+		if err != nil {
+			return "", fmt.Errorf("Cannot read secret: command \"%s\" failed: %s", s.Command, err)
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+
+	return "", fmt.Errorf("No secret source defined")
+}
@@ -18,103 +18,289 @@ package gitlab
 
 import (
 	"encoding/json"
-	"fmt"
 
 	"github.com/imdario/mergo"
 	"github.com/jinzhu/copier"
 	"github.com/xanzy/go-gitlab"
 )
 
+// SlackSettings represents a project's Slack settings.
+type SlackSettings struct {
+	Mode       string          `json:"mode,omitempty"`
+	Active     bool            `json:"active"`
+	Events     []string        `json:"events"`
+	Properties SlackProperties `json:"properties,omitempty"`
+}
+
+// SlackProperties represents the Slack integration's properties. Channels set
+// per event override the default channel for that event's notifications.
+type SlackProperties struct {
+	WebHook                   string `json:"webhook,omitempty"`
+	Username                  string `json:"username,omitempty"`
+	Channel                   string `json:"channel,omitempty"`
+	NotifyOnlyBrokenPipelines *bool  `json:"notify_only_broken_pipelines,omitempty"`
+	NotifyOnlyDefaultBranch   *bool  `json:"notify_only_default_branch,omitempty"`
+	BranchesToBeNotified      string `json:"branches_to_be_notified,omitempty"`
+	AlertChannel              string `json:"alert_channel,omitempty"`
+	ConfidentialIssueChannel  string `json:"confidential_issue_channel,omitempty"`
+	ConfidentialNoteChannel   string `json:"confidential_note_channel,omitempty"`
+	DeploymentChannel         string `json:"deployment_channel,omitempty"`
+	IssueChannel              string `json:"issue_channel,omitempty"`
+	MergeRequestChannel       string `json:"merge_request_channel,omitempty"`
+	NoteChannel               string `json:"note_channel,omitempty"`
+	TagPushChannel            string `json:"tag_push_channel,omitempty"`
+	PipelineChannel           string `json:"pipeline_channel,omitempty"`
+	PushChannel               string `json:"push_channel,omitempty"`
+	VulnerabilityChannel      string `json:"vulnerability_channel,omitempty"`
+	WikiPageChannel           string `json:"wiki_page_channel,omitempty"`
+}
+
 func updateSlackService(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
 	// Fetch Slack Service settings from config file and return if a nil object is returned.
-	cfgSettings := cfg.SlackSettings(p.Namespace.FullPath)
+	cfgSettings := cfg.SlackSettings(p.PathWithNamespace)
 	// We don't error if no Slack settings are found for the namespace or a parent namespace, we just
 	// print a message and move on...
 	if cfgSettings == nil {
-		fmt.Printf("Cannot find Slack settings for namespace %s or any of it's parents, skipping\n", p.Namespace.FullPath)
+		cfg.printf("Cannot find Slack settings for namespace %s or any of it's parents, skipping\n", p.Namespace.FullPath)
 		return nil
 	}
 	if compareObjects(cfgSettings, &SlackSettings{}) {
 		return nil
 	}
+	exact, err := exactMode(cfgSettings.Mode)
+	if err != nil {
+		return err
+	}
 
 	// Fetch current Slack Service settings and properties.
 	projectSettings, _, err := client.Services.GetSlackService(p.ID)
 	if err != nil {
+		cfg.fetchFailed(p, "Slack", err)
 		return nil
 	}
+	if projectSettings.Properties == nil {
+		projectSettings.Properties = &gitlab.SlackServiceProperties{}
+	}
 
 	// Create a gitlab.SlackService object with our desired service and properties by
 	// taking what is current and applying our changes on top, thus we do not override
 	// any settings that we don't define in our config.
 	newSettings := &gitlab.SlackService{}
-	if err := copier.Copy(&newSettings, &cfgSettings); err != nil {
+	if err := copier.CopyWithOption(newSettings, projectSettings, copier.Option{DeepCopy: true}); err != nil {
 		return err
 	}
 
-	if err := mergo.Merge(newSettings.Properties, cfgSettings.Properties, mergo.WithOverride); err != nil {
+	// The webhook isn't applied here as the API never returns it, so it can't be
+	// compared.
+	cfgProperties := &gitlab.SlackServiceProperties{
+		Username:                 cfgSettings.Properties.Username,
+		Channel:                  cfgSettings.Properties.Channel,
+		BranchesToBeNotified:     cfgSettings.Properties.BranchesToBeNotified,
+		AlertChannel:             cfgSettings.Properties.AlertChannel,
+		ConfidentialIssueChannel: cfgSettings.Properties.ConfidentialIssueChannel,
+		ConfidentialNoteChannel:  cfgSettings.Properties.ConfidentialNoteChannel,
+		DeploymentChannel:        cfgSettings.Properties.DeploymentChannel,
+		IssueChannel:             cfgSettings.Properties.IssueChannel,
+		MergeRequestChannel:      cfgSettings.Properties.MergeRequestChannel,
+		NoteChannel:              cfgSettings.Properties.NoteChannel,
+		TagPushChannel:           cfgSettings.Properties.TagPushChannel,
+		PipelineChannel:          cfgSettings.Properties.PipelineChannel,
+		PushChannel:              cfgSettings.Properties.PushChannel,
+		VulnerabilityChannel:     cfgSettings.Properties.VulnerabilityChannel,
+		WikiPageChannel:          cfgSettings.Properties.WikiPageChannel,
+	}
+	if err := mergo.Merge(newSettings.Properties, cfgProperties, mergo.WithOverride); err != nil {
 		return err
 	}
 	newSettings.Active = cfgSettings.Active
 	// This is the service default so we set it to true here; consumers can set it to
-	// false via the config file if it should be disabled.
-	newSettings.Properties.NotifyOnlyBrokenPipelines = true
+	// false via the config file if it should be disabled. In exact mode anything not
+	// enabled in the config is switched off.
+	newSettings.Properties.NotifyOnlyBrokenPipelines = gitlab.BoolValue(!exact)
+	if exact {
+		newSettings.Properties.NotifyOnlyDefaultBranch = false
+		newSettings.AlertEvents = false
+		newSettings.ConfidentialIssuesEvents = false
+		newSettings.ConfidentialNoteEvents = false
+		newSettings.DeploymentEvents = false
+		newSettings.IssuesEvents = false
+		newSettings.MergeRequestsEvents = false
+		newSettings.NoteEvents = false
+		newSettings.PipelineEvents = false
+		newSettings.PushEvents = false
+		newSettings.TagPushEvents = false
+		newSettings.VulnerabilityEvents = false
+		newSettings.WikiPageEvents = false
+	}
+	if cfgSettings.Properties.NotifyOnlyBrokenPipelines != nil {
+		newSettings.Properties.NotifyOnlyBrokenPipelines = gitlab.BoolValue(*cfgSettings.Properties.NotifyOnlyBrokenPipelines)
+	}
+	if cfgSettings.Properties.NotifyOnlyDefaultBranch != nil {
+		newSettings.Properties.NotifyOnlyDefaultBranch = gitlab.BoolValue(*cfgSettings.Properties.NotifyOnlyDefaultBranch)
+	}
 	for _, e := range cfgSettings.Events {
 		switch e {
+		case "alert":
+			newSettings.AlertEvents = true
+		case "confidential_issues":
+			newSettings.ConfidentialIssuesEvents = true
+		case "confidential_note":
+			newSettings.ConfidentialNoteEvents = true
+		case "deployment":
+			newSettings.DeploymentEvents = true
 		case "issues":
 			newSettings.IssuesEvents = true
 		case "merge_request":
 			newSettings.MergeRequestsEvents = true
+		case "note":
+			newSettings.NoteEvents = true
 		case "pipeline":
 			newSettings.PipelineEvents = true
 		case "push":
 			newSettings.PushEvents = true
 		case "tags":
 			newSettings.TagPushEvents = true
+		case "vulnerability":
+			newSettings.VulnerabilityEvents = true
+		case "wiki_page":
+			newSettings.WikiPageEvents = true
 		default:
-			fmt.Printf("Unsupported event type: %s\n", e)
+			cfg.printf("Unsupported event type: %s\n", e)
 		}
 	}
 
-	// We need to set these even though they aren't use otherwise compareObjects
-	// will never return true.
-	newSettings.ID = projectSettings.ID
-	newSettings.Title = projectSettings.Title
-	newSettings.CreatedAt = projectSettings.CreatedAt
-	newSettings.UpdatedAt = projectSettings.UpdatedAt
+	// Slack is disabled rather than removed so it's settings are kept, and there is
+	// nothing else to compare when it should be inactive.
+	if !cfgSettings.Active {
+		cfg.check(p, "Slack", !projectSettings.Active, "active: true -> false")
+
+		if !projectSettings.Active {
+			cfg.printf("Project %s's Slack settings don't need updating\n", p.PathWithNamespace)
+			return nil
+		}
+
+		cfg.printf("Project %s's Slack integration needs disabling ... ", p.PathWithNamespace)
 
-	// Return if our proposed config matches the actual config
+		if cfg.DryRun {
+			cfg.printf("skipping because this is a dry run\n")
+			return nil
+		}
+
+		opts, err := slackOptions(projectSettings)
+		if err != nil {
+			return err
+		}
+		opts.Active = gitlab.Bool(false)
+
+		audit, err := cfg.snapshot(p, "slack", "", true, projectSettings)
+		if err != nil {
+			return err
+		}
+
+		cfg.printf("Updating project ... ")
+
+		if err := setIntegration(client, p.ID, "slack", opts, audit); err != nil {
+			cfg.updated(p, "Slack", err)
+			return err
+		}
+		cfg.updated(p, "Slack", nil)
+		cfg.printf("Success!\n")
+
+		return nil
+	}
+
+	// Return if our proposed config matches the actual config; the webhook can't be
+	// compared as the API never returns it.
 	if compareObjects(projectSettings, newSettings) {
-		fmt.Printf("Project %s's Slack settings don't need updating\n", p.PathWithNamespace)
+		if cfgSettings.Properties.WebHook != "" {
+			cfg.unverifiable(p, "Slack", "webhook: not returned by GitLab")
+		} else {
+			cfg.check(p, "Slack", true, "")
+		}
+		cfg.printf("Project %s's Slack settings don't need updating\n", p.PathWithNamespace)
 		return nil
 	}
 
-	fmt.Printf("Project %s's Slack settings need updating ... ", p.PathWithNamespace)
+	cfg.check(p, "Slack", false, diffObjects(projectSettings, newSettings))
+
+	cfg.printf("Project %s's Slack settings need updating ... ", p.PathWithNamespace)
 
 	if cfg.DryRun {
-		fmt.Printf("skipping because this is a dry run\n")
+		cfg.printf("skipping because this is a dry run\n")
 		return nil
 	}
 
-	opts := &gitlab.SetSlackServiceOptions{}
+	opts, err := slackOptions(newSettings)
+	if err != nil {
+		return err
+	}
+	if cfgSettings.Properties.WebHook != "" {
+		opts.WebHook = &cfgSettings.Properties.WebHook
+	}
+	audit, err := cfg.snapshot(p, "slack", "", projectSettings.Active, projectSettings)
+	if err != nil {
+		return err
+	}
 
-	svcData, _ := json.Marshal(newSettings.Service)
-	if err := json.Unmarshal(svcData, &opts); err != nil {
+	cfg.printf("Updating project ... ")
+
+	if err := setIntegration(client, p.ID, "slack", opts, audit); err != nil {
+		cfg.updated(p, "Slack", err)
 		return err
 	}
+	cfg.updated(p, "Slack", nil)
+	cfg.printf("Success!\n")
 
-	propData, _ := json.Marshal(newSettings.Properties)
-	if err := json.Unmarshal(propData, &opts); err != nil {
+	return nil
+}
+
+// restoreSlackService restores a project's Slack service settings, or removes
+// them if the service wasn't active. A webhook changed during a run isn't
+// restored, as the API never returns it.
+func restoreSlackService(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	before := &gitlab.SlackService{}
+	if err := json.Unmarshal(s.Before, before); err != nil {
 		return err
 	}
 
-	fmt.Printf("Updating project ... ")
+	if !s.Existed {
+		_, err := client.Services.DeleteSlackService(s.ProjectID, audit)
+		return err
+	}
 
-	_, err = client.Services.SetSlackService(p.ID, opts)
+	opts, err := slackOptions(before)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Success!\n")
 
-	return nil
+	return setIntegration(client, s.ProjectID, "slack", opts, audit)
+}
+
+// slackIntegrationOptions represents the parameters used to update the Slack
+// integration, including those gitlab.SetSlackServiceOptions doesn't support.
+type slackIntegrationOptions struct {
+	gitlab.SetSlackServiceOptions
+	Active               *bool   `json:"active,omitempty"`
+	VulnerabilityChannel *string `json:"vulnerability_channel,omitempty"`
+	VulnerabilityEvents  *bool   `json:"vulnerability_events,omitempty"`
+}
+
+// slackOptions returns the parameters to set a project's Slack settings to those
+// given. The API never returns the webhook, so the current one is kept.
+func slackOptions(settings *gitlab.SlackService) (*slackIntegrationOptions, error) {
+	opts := &slackIntegrationOptions{}
+
+	svcData, _ := json.Marshal(settings.Service)
+	if err := json.Unmarshal(svcData, &opts); err != nil {
+		return nil, err
+	}
+
+	propData, _ := json.Marshal(settings.Properties)
+	if err := json.Unmarshal(propData, &opts); err != nil {
+		return nil, err
+	}
+
+	opts.WebHook = nil
+
+	return opts, nil
 }
@@ -0,0 +1,119 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdateSlackService(t *testing.T) {
+	active := `{
+		"active": true,
+		"pipeline_events": true,
+		"properties": {"channel": "dev", "notify_only_broken_pipelines": true}
+	}`
+	inactive := `{
+		"active": false,
+		"pipeline_events": true,
+		"properties": {"channel": "dev", "notify_only_broken_pipelines": true}
+	}`
+
+	tests := []struct {
+		name             string
+		current          string
+		active           bool
+		events           []string
+		wantWrites       []string
+		wantCompliant    bool
+		wantUnverifiable bool
+		wantBody         []string
+	}{
+		{
+			name:             "matches apart from the webhook",
+			current:          active,
+			active:           true,
+			events:           []string{"pipeline"},
+			wantWrites:       []string{},
+			wantCompliant:    true,
+			wantUnverifiable: true,
+		},
+		{
+			name:       "vulnerability event missing",
+			current:    active,
+			active:     true,
+			events:     []string{"pipeline", "vulnerability"},
+			wantWrites: []string{"PUT /projects/1/integrations/slack"},
+			wantBody:   []string{`"vulnerability_events":true`, `"vulnerability_channel":"security"`, `"webhook":"https://hooks.slack.com/services/T04"`},
+		},
+		{
+			name:       "disabled",
+			current:    active,
+			events:     []string{"pipeline"},
+			wantWrites: []string{"PUT /projects/1/integrations/slack"},
+			wantBody:   []string{`"active":false`, `"pipeline_events":true`},
+		},
+		{
+			name:          "already disabled",
+			current:       inactive,
+			events:        []string{"pipeline"},
+			wantWrites:    []string{},
+			wantCompliant: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client, cfg := newFakeGitLab(t, map[string]string{
+				"GET /projects/1/services/slack":     tt.current,
+				"PUT /projects/1/integrations/slack": tt.current,
+			})
+			slack := &cfg.Groups[0].Integrations.Slack
+			slack.Active = tt.active
+			slack.Events = tt.events
+			slack.Properties.WebHook = "https://hooks.slack.com/services/T04"
+			slack.Properties.Channel = "dev"
+			if contains(tt.events, "vulnerability") {
+				slack.Properties.VulnerabilityChannel = "security"
+			}
+
+			if err := updateSlackService(client, testProject, cfg); err != nil {
+				t.Fatalf("updateSlackService() error = %v", err)
+			}
+
+			if got := f.writes(); !reflect.DeepEqual(got, tt.wantWrites) {
+				t.Fatalf("writes = %v, want %v", got, tt.wantWrites)
+			}
+
+			check := onlyCheck(t, cfg, "Slack")
+			if check.Compliant != tt.wantCompliant || check.Unverifiable != tt.wantUnverifiable {
+				t.Errorf("check = %+v, want compliant %t and unverifiable %t", check, tt.wantCompliant, tt.wantUnverifiable)
+			}
+
+			if len(tt.wantWrites) == 0 {
+				return
+			}
+			body := f.request(t, tt.wantWrites[0]).Body
+			for _, want := range tt.wantBody {
+				if !strings.Contains(body, want) {
+					t.Errorf("request body %s doesn't contain %s", body, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// DefaultSnapshotDir is the directory snapshots are written to when no directory
+// is configured.
+const DefaultSnapshotDir = ".repo-settings/snapshots"
+
+// snapshot represents the state of one of a project's settings before it was
+// changed during a run. Before holds the prior state of the item; for items that
+// didn't exist yet it holds the item about to be created, so it can be found and
+// removed again.
+type snapshot struct {
+	Time      time.Time       `json:"time"`
+	RunID     string          `json:"run_id"`
+	Project   string          `json:"project"`
+	ProjectID int             `json:"project_id"`
+	Setting   string          `json:"setting"`
+	Name      string          `json:"name,omitempty"`
+	Existed   bool            `json:"existed"`
+	Before    json.RawMessage `json:"before"`
+}
+
+// restorer restores a setting to the state recorded in a snapshot, passing audit
+// to each call it makes so the change is attributed to the snapshot.
+type restorer func(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error
+
+// restorers holds the restorer of each setting that is snapshotted.
+var restorers = map[string]restorer{
+	"badge":                       restoreBadge,
+	"ci_variable":                 restoreCIVariable,
+	"container_expiration_policy": restoreContainerExpirationPolicy,
+	"deploy_key":                  restoreDeployKey,
+	"deploy_token":                restoreDeployToken,
+	"files":                       restoreFiles,
+	"group_share":                 restoreGroupShare,
+	"integration":                 restoreIntegration,
+	"jira":                        restoreJiraService,
+	"job_token_allowlist_group":   restoreJobTokenAllowlistGroup,
+	"job_token_allowlist_project": restoreJobTokenAllowlistProject,
+	"job_token_scope":             restoreJobTokenScope,
+	"label":                       restoreLabel,
+	"lifecycle_archive":           restoreArchive,
+	"lifecycle_rename":            restoreRename,
+	"lifecycle_transfer":          restoreTransfer,
+	"member":                      restoreMember,
+	"merge_request_approvals":     restoreMergeRequestApprovals,
+	"mirror":                      restoreMirror,
+	"pipeline_schedule":           restorePipelineSchedule,
+	"project":                     restoreProjectAttributes,
+	"protected_branch":            restoreProtectedBranch,
+	"slack":                       restoreSlackService,
+}
+
+// runIDPattern matches run IDs, including those without sub-second precision or
+// a random suffix written by earlier versions.
+var runIDPattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}(\.[0-9]+)?Z(-[0-9a-f]+)?$`)
+
+// NewRunID returns an ID for a run based on the current time, with a random suffix
+// so runs started at the same time don't share snapshots.
+func NewRunID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return fmt.Sprintf("%s-%x", time.Now().UTC().Format("20060102T150405.000000Z"), suffix)
+}
+
+// validateRunID returns an error if a run ID isn't one returned by NewRunID, so it
+// can safely be used in a path.
+func validateRunID(runID string) error {
+	if !runIDPattern.MatchString(runID) {
+		return fmt.Errorf("Invalid run ID \"%s\"", runID)
+	}
+
+	return nil
+}
+
+// snapshot records the state of one of a project's settings before it's changed,
+// so the change can be rolled back. Handlers call it just before they write, and
+// don't make the change if the snapshot can't be written. The returned option
+// attributes the calls making the change to the setting in the audit log; the
+// values of secrets are redacted from the audit entries of those calls.
+func (c *Config) snapshot(p *gitlab.Project, setting, name string, existed bool, before interface{}, secrets ...string) (gitlab.RequestOptionFunc, error) {
+	data, err := json.Marshal(before)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &snapshot{
+		Time:      time.Now().UTC(),
+		RunID:     c.RunID,
+		Project:   p.PathWithNamespace,
+		ProjectID: p.ID,
+		Setting:   setting,
+		Name:      name,
+		Existed:   existed,
+		Before:    data,
+	}
+	entry, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshots may hold secrets such as CI/CD variable values, so they're only
+	// readable by the current user.
+	if err := os.MkdirAll(c.snapshotDir(), 0700); err != nil {
+		return nil, fmt.Errorf("Failed to create snapshot directory: %s", err)
+	}
+	f, err := os.OpenFile(snapshotPath(c.snapshotDir(), c.RunID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to write snapshot: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(entry, '\n')); err != nil {
+		return nil, fmt.Errorf("Failed to write snapshot: %s", err)
+	}
+
+	return auditOption(s, false, secrets...), nil
+}
+
+// snapshotDir returns the directory snapshots are written to.
+func (c *Config) snapshotDir() string {
+	if c.SnapshotDir == "" {
+		return DefaultSnapshotDir
+	}
+
+	return c.SnapshotDir
+}
+
+// snapshotPath returns the path of a run's snapshot file.
+func snapshotPath(dir, runID string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.jsonl", runID))
+}
+
+// readSnapshots returns the snapshots recorded during a run, in the order they
+// were recorded.
+func readSnapshots(dir, runID string) ([]*snapshot, error) {
+	f, err := os.Open(snapshotPath(dir, runID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("Cannot find snapshots for run %s in %s", runID, dir)
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	snapshots := []*snapshot{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		s := &snapshot{}
+		if err := json.Unmarshal(scanner.Bytes(), s); err != nil {
+			return nil, fmt.Errorf("Failed to read snapshot: %s", err)
+		}
+		snapshots = append(snapshots, s)
+	}
+
+	return snapshots, scanner.Err()
+}
+
+// RollbackRun restores the settings changed during a run to the state they were
+// in before the run. Changes are undone in reverse order, so a setting changed
+// more than once ends up in it's original state.
+func RollbackRun(cfg *Config, runID string) error {
+	if err := validateRunID(runID); err != nil {
+		return err
+	}
+
+	// Changes made by a rollback are logged under the run they roll back.
+	cfg.RunID = runID
+
+	client, err := newAuditedClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := readSnapshots(cfg.snapshotDir(), runID)
+	if err != nil {
+		return err
+	}
+
+	// Check every setting can be restored before restoring any of them.
+	for _, s := range snapshots {
+		if _, ok := restorers[s.Setting]; !ok {
+			return fmt.Errorf("Cannot restore unknown setting \"%s\"", s.Setting)
+		}
+	}
+
+	failed := 0
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		s := snapshots[i]
+		restore := restorers[s.Setting]
+
+		name := s.Setting
+		if s.Name != "" {
+			name = fmt.Sprintf("%s (%s)", s.Setting, s.Name)
+		}
+		cfg.printf("Restoring project %s's %s ... ", s.Project, name)
+
+		if err := restore(client, s, auditOption(s, true)); err != nil {
+			cfg.printf("Failed to restore: %s\n", err)
+			failed++
+			continue
+		}
+		cfg.printf("Success!\n")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Failed to restore %d of %d settings changed during run %s", failed, len(snapshots), runID)
+	}
+
+	return nil
+}
+
+// projectAttributes returns the current values of the project attributes set by
+// opts, so they can be restored by editing the project with them again.
+func projectAttributes(project *gitlab.Project, opts *gitlab.EditProjectOptions) map[string]interface{} {
+	current := map[string]interface{}{}
+	data, _ := json.Marshal(project)
+	json.Unmarshal(data, &current)
+
+	wanted := map[string]interface{}{}
+	data, _ = json.Marshal(opts)
+	json.Unmarshal(data, &wanted)
+
+	attrs := map[string]interface{}{}
+	for k := range wanted {
+		// Public jobs are still set using their old name.
+		pk := k
+		if k == "public_builds" {
+			pk = "public_jobs"
+		}
+		attrs[k] = current[pk]
+	}
+
+	return attrs
+}
+
+func restoreProjectAttributes(client *gitlab.Client, s *snapshot, audit gitlab.RequestOptionFunc) error {
+	opts := &gitlab.EditProjectOptions{}
+	if err := json.Unmarshal(s.Before, opts); err != nil {
+		return err
+	}
+
+	_, _, err := client.Projects.EditProject(s.ProjectID, opts, audit, audit)
+	return err
+}
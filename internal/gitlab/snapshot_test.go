@@ -0,0 +1,57 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import "testing"
+
+func TestNewRunID(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := NewRunID()
+		if err := validateRunID(id); err != nil {
+			t.Fatalf("NewRunID() = %q, which isn't valid: %s", id, err)
+		}
+		if seen[id] {
+			t.Fatalf("NewRunID() returned %q twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestValidateRunID(t *testing.T) {
+	tests := []struct {
+		runID   string
+		wantErr bool
+	}{
+		{runID: "20190102T030405Z"},
+		{runID: "20190102T030405.123456Z"},
+		{runID: "20190102T030405.123456Z-0a1b2c3d"},
+		{runID: "", wantErr: true},
+		{runID: "latest", wantErr: true},
+		{runID: "../20190102T030405Z", wantErr: true},
+		{runID: "20190102T030405Z/../../etc/passwd", wantErr: true},
+		{runID: "20190102T030405Z-XYZ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.runID, func(t *testing.T) {
+			if err := validateRunID(tt.runID); (err != nil) != tt.wantErr {
+				t.Errorf("validateRunID() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
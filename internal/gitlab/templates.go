@@ -0,0 +1,175 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// defaultTemplatesBranch is the branch template files are committed to when no
+// branch is configured.
+const defaultTemplatesBranch = "repo-settings/templates"
+
+// TemplatesSettings represents the issue and merge request description
+// templates of a project. Issue and MergeRequest point at local files used as
+// the project's default descriptions, while IssueTemplates and
+// MergeRequestTemplates are committed to the repository through a merge request.
+type TemplatesSettings struct {
+	Issue                 string             `json:"issue,omitempty"`
+	MergeRequest          string             `json:"merge_request,omitempty"`
+	IssueTemplates        []*TemplateSetting `json:"issue_templates,omitempty"`
+	MergeRequestTemplates []*TemplateSetting `json:"merge_request_templates,omitempty"`
+	Branch                string             `json:"branch,omitempty"`
+}
+
+// TemplateSetting represents a description template committed to a project. The
+// name is used as the file name, and is what users pick in GitLab's template
+// dropdown.
+type TemplateSetting struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+func updateTemplatesSettings(client *gitlab.Client, p *gitlab.Project, cfg *Config) error {
+	// Fetch templates from config file and return if a nil object is returned.
+	cfgSettings := cfg.TemplatesSettings(p.PathWithNamespace)
+	if cfgSettings == nil || compareObjects(cfgSettings, &TemplatesSettings{}) {
+		return nil
+	}
+
+	if err := updateDefaultDescriptions(client, p, cfg, cfgSettings); err != nil {
+		return err
+	}
+
+	return updateTemplateFiles(client, p, cfg, cfgSettings)
+}
+
+// updateDefaultDescriptions sets the project's default issue and merge request
+// descriptions.
+func updateDefaultDescriptions(client *gitlab.Client, p *gitlab.Project, cfg *Config, cfgSettings *TemplatesSettings) error {
+	if cfgSettings.Issue == "" && cfgSettings.MergeRequest == "" {
+		return nil
+	}
+
+	opts := &gitlab.EditProjectOptions{}
+	if cfgSettings.Issue != "" {
+		content, err := readLocalFile(cfgSettings.Issue)
+		if err != nil {
+			return err
+		}
+		opts.IssuesTemplate = &content
+	}
+	if cfgSettings.MergeRequest != "" {
+		content, err := readLocalFile(cfgSettings.MergeRequest)
+		if err != nil {
+			return err
+		}
+		opts.MergeRequestsTemplate = &content
+	}
+
+	// Fetch current project settings.
+	projectSettings, _, err := client.Projects.GetProject(p.ID, nil)
+	if err != nil {
+		cfg.fetchFailed(p, "Default description templates", err)
+		return nil
+	}
+
+	// Return if our proposed config matches the actual config
+	if (opts.IssuesTemplate == nil || *opts.IssuesTemplate == projectSettings.IssuesTemplate) &&
+		(opts.MergeRequestsTemplate == nil || *opts.MergeRequestsTemplate == projectSettings.MergeRequestsTemplate) {
+		cfg.check(p, "Default description templates", true, "")
+		cfg.printf("Project %s's Default description templates don't need updating\n", p.PathWithNamespace)
+		return nil
+	}
+
+	offending := []string{}
+	if opts.IssuesTemplate != nil && *opts.IssuesTemplate != projectSettings.IssuesTemplate {
+		offending = append(offending, "issue description differs")
+	}
+	if opts.MergeRequestsTemplate != nil && *opts.MergeRequestsTemplate != projectSettings.MergeRequestsTemplate {
+		offending = append(offending, "merge request description differs")
+	}
+	cfg.check(p, "Default description templates", false, strings.Join(offending, ", "))
+
+	cfg.printf("Project %s's Default description templates need updating ... ", p.PathWithNamespace)
+
+	if cfg.DryRun {
+		cfg.printf("skipping because this is a dry run\n")
+		return nil
+	}
+
+	audit, err := cfg.snapshot(p, "project", "default description templates", true, projectAttributes(projectSettings, opts))
+	if err != nil {
+		return err
+	}
+
+	cfg.printf("Updating project ... ")
+
+	_, _, err = client.Projects.EditProject(p.ID, opts, audit)
+	if err != nil {
+		cfg.updated(p, "Default description templates", err)
+		return err
+	}
+	cfg.updated(p, "Default description templates", nil)
+	cfg.printf("Success!\n")
+
+	return nil
+}
+
+// updateTemplateFiles commits issue and merge request templates into the
+// project's .gitlab directory through a merge request.
+func updateTemplateFiles(client *gitlab.Client, p *gitlab.Project, cfg *Config, cfgSettings *TemplatesSettings) error {
+	files := []*repositoryFile{}
+
+	dirs := []struct {
+		path      string
+		templates []*TemplateSetting
+	}{
+		{".gitlab/issue_templates", cfgSettings.IssueTemplates},
+		{".gitlab/merge_request_templates", cfgSettings.MergeRequestTemplates},
+	}
+	for _, d := range dirs {
+		for _, t := range d.templates {
+			if t.Name == "" || t.Source == "" {
+				return fmt.Errorf("Templates must have a name and source")
+			}
+
+			content, err := readLocalFile(t.Source)
+			if err != nil {
+				return err
+			}
+			files = append(files, &repositoryFile{
+				Path:    fmt.Sprintf("%s/%s.md", d.path, t.Name),
+				Content: content,
+			})
+		}
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	branch := cfgSettings.Branch
+	if branch == "" {
+		branch = defaultTemplatesBranch
+	}
+
+	return updateFilesViaMergeRequest(client, p, cfg, "Description templates", branch, files)
+}
@@ -0,0 +1,150 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// maxWebhookSize is the maximum size of a webhook's body. Project events are
+// small, so anything larger isn't one we act on.
+const maxWebhookSize = 1 << 20
+
+// webhookEvents are the events that make the settings be applied to a project.
+var webhookEvents = []string{"project_create", "project_rename", "project_transfer"}
+
+// webhookEvent represents the fields of a GitLab system hook or group webhook
+// that are used to find the project it's about.
+type webhookEvent struct {
+	EventName         string `json:"event_name"`
+	ProjectID         int    `json:"project_id"`
+	PathWithNamespace string `json:"path_with_namespace"`
+}
+
+// WebhookHandler receives GitLab system hooks and group webhooks, and applies the
+// settings of the group closest to a created, renamed or transferred project to
+// it. Events are processed one at a time in the order they were received, so the
+// webhook can be answered before the settings are applied.
+type WebhookHandler struct {
+	cfg    *Config
+	client *gitlab.Client
+	secret string
+	events chan *webhookEvent
+}
+
+// NewWebhookHandler returns a WebhookHandler which only accepts webhooks sent with
+// the secret token, and starts processing the events it receives.
+func NewWebhookHandler(cfg *Config, secret string) (*WebhookHandler, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("Missing required webhook secret token")
+	}
+
+	client, err := newAuditedClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &WebhookHandler{
+		cfg:    cfg,
+		client: client,
+		secret: secret,
+		events: make(chan *webhookEvent, 100),
+	}
+	go h.process()
+
+	return h, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.Header.Get("X-Gitlab-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) != 1 {
+		http.Error(w, "Invalid secret token", http.StatusUnauthorized)
+		return
+	}
+
+	e := &webhookEvent{}
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookSize)
+	if err := json.NewDecoder(r.Body).Decode(e); err != nil {
+		http.Error(w, "Invalid event", http.StatusBadRequest)
+		return
+	}
+
+	if !contains(webhookEvents, e.EventName) || e.ProjectID == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	select {
+	case h.events <- e:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "Too many events queued", http.StatusServiceUnavailable)
+	}
+}
+
+// process applies the settings for each event received.
+func (h *WebhookHandler) process() {
+	for e := range h.events {
+		if err := h.apply(e); err != nil {
+			h.cfg.printf("Failed to apply settings to project %s: %s\n", e.PathWithNamespace, err)
+		}
+	}
+}
+
+// apply applies the settings of the group closest to the project an event is
+// about. Each event is a run of it's own, using a copy of the config with it's
+// own run ID and checks, so the changes it makes can be rolled back.
+func (h *WebhookHandler) apply(e *webhookEvent) error {
+	cfg := *h.cfg
+	cfg.RunID = NewRunID()
+	cfg.checks = nil
+	cfg.unattended = true
+
+	cfg.printf("Received %s event for project %s\n", e.EventName, e.PathWithNamespace)
+
+	// Look the project up as the event may be about a project that has since been
+	// moved again.
+	p, _, err := h.client.Projects.GetProject(e.ProjectID, nil)
+	if err != nil {
+		return err
+	}
+	if cfg.groupSettings(p.PathWithNamespace) == nil {
+		cfg.printf("Project %s isn't in a configured group, skipping\n", p.PathWithNamespace)
+		return nil
+	}
+	if p.Archived {
+		cfg.printf("Project %s is archived, skipping\n", p.PathWithNamespace)
+		return nil
+	}
+
+	if !cfg.DryRun {
+		cfg.printf("Starting run %s, changed settings are snapshotted to %s\n", cfg.RunID, snapshotPath(cfg.snapshotDir(), cfg.RunID))
+	}
+
+	return updateProject(h.client, p, &cfg)
+}
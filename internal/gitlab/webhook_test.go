@@ -0,0 +1,111 @@
+//
+// Copyright © 2019 Stephen Hoekstra
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package gitlab
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		body   string
+		want   int
+		queued bool
+	}{
+		{
+			name:   "valid event",
+			method: http.MethodPost,
+			token:  "secret",
+			body:   `{"event_name":"project_create","project_id":1,"path_with_namespace":"acme/api"}`,
+			want:   http.StatusAccepted,
+			queued: true,
+		},
+		{
+			name:   "missing token",
+			method: http.MethodPost,
+			body:   `{"event_name":"project_create","project_id":1}`,
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "wrong token",
+			method: http.MethodPost,
+			token:  "secreT",
+			body:   `{"event_name":"project_create","project_id":1}`,
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "token prefix",
+			method: http.MethodPost,
+			token:  "secretsecret",
+			body:   `{"event_name":"project_create","project_id":1}`,
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "wrong method",
+			method: http.MethodGet,
+			token:  "secret",
+			want:   http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "invalid body",
+			method: http.MethodPost,
+			token:  "secret",
+			body:   `not json`,
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "body too large",
+			method: http.MethodPost,
+			token:  "secret",
+			body:   `{"event_name":"` + strings.Repeat("a", maxWebhookSize) + `"}`,
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "ignored event",
+			method: http.MethodPost,
+			token:  "secret",
+			body:   `{"event_name":"push","project_id":1}`,
+			want:   http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &WebhookHandler{secret: "secret", events: make(chan *webhookEvent, 1)}
+
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("X-Gitlab-Token", tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := len(h.events) == 1; got != tt.queued {
+				t.Errorf("event queued = %t, want %t", got, tt.queued)
+			}
+		})
+	}
+}